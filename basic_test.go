@@ -366,6 +366,60 @@ func TestIterateRange(t *testing.T) {
 	expectTraverse(t, trav, "low", "good", 2)
 }
 
+func TestIterateChangedSince(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	r := mrand.New(mrand.NewSource(1))
+
+	// Track the version at which each key was last set.
+	setAt := map[string]int64{}
+	for v := int64(1); v <= 10; v++ {
+		for i := 0; i < 20; i++ {
+			key := string([]byte{byte('a' + r.Intn(26)), byte('a' + r.Intn(26))})
+			if r.Intn(4) == 0 {
+				tree.Remove([]byte(key))
+				delete(setAt, key)
+			} else {
+				tree.Set([]byte(key), []byte(key))
+				setAt[key] = v
+			}
+		}
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	// Changes to the working tree are visited with the next version.
+	tree.Set([]byte("new"), []byte("new"))
+	setAt["new"] = 11
+
+	for since := int64(0); since <= 11; since++ {
+		expect := []string{}
+		for key, v := range setAt {
+			if v > since {
+				expect = append(expect, key)
+			}
+		}
+		sort.Strings(expect)
+
+		visited := []string{}
+		stopped := tree.IterateChangedSince(since, func(key, value []byte, version int64) bool {
+			require.Equal(t, key, value)
+			require.Equal(t, setAt[string(key)], version)
+			visited = append(visited, string(key))
+			return false
+		})
+		require.False(t, stopped)
+		require.Equal(t, expect, visited, "since %v", since)
+	}
+
+	count := 0
+	stopped := tree.IterateChangedSince(5, func(key, value []byte, version int64) bool {
+		count++
+		return count == 2
+	})
+	require.True(t, stopped)
+	require.Equal(t, 2, count)
+}
+
 func TestPersistence(t *testing.T) {
 	db := db.NewMemDB()
 
@@ -471,3 +525,57 @@ func TestTreeProof(t *testing.T) {
 		}
 	}
 }
+
+func TestOrderStatistics(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, tree.CountRange(nil, nil))
+	key, _ := tree.Median()
+	require.Nil(t, key)
+
+	keys := []string{}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, string([]byte{'a' + byte(i/10), '0' + byte(i%10)}))
+		tree.Set([]byte(keys[i]), []byte{byte(i)})
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// countKeys counts the keys in [start, end) by iterating.
+	countKeys := func(start, end []byte) int64 {
+		count := int64(0)
+		tree.IterateRange(start, end, true, func(key, value []byte) bool {
+			count++
+			return false
+		})
+		return count
+	}
+	bounds := [][]byte{nil, []byte(""), []byte("a"), []byte("a5"), []byte("a55"), []byte("c0"), []byte("e9"), []byte("z")}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			expect := countKeys(start, end)
+			if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+				expect = 0
+			}
+			require.Equal(t, expect, tree.CountRange(start, end), "range %q-%q", start, end)
+		}
+	}
+
+	for i, key := range keys {
+		require.EqualValues(t, i, tree.Rank([]byte(key)))
+	}
+	require.EqualValues(t, 6, tree.Rank([]byte("a55")))
+	require.EqualValues(t, 50, tree.Rank([]byte("z")))
+
+	key, value := tree.Median()
+	require.Equal(t, "c4", string(key))
+	require.Equal(t, []byte{24}, value)
+	key, _ = tree.Select(0)
+	require.Equal(t, "a0", string(key))
+	key, _ = tree.Select(1)
+	require.Equal(t, "e9", string(key))
+	key, _ = tree.Select(0.9)
+	require.Equal(t, "e4", string(key))
+	key, _ = tree.Select(1.5)
+	require.Nil(t, key)
+}
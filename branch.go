@@ -0,0 +1,100 @@
+package iavl
+
+import "github.com/pkg/errors"
+
+// TreeBranch is a working tree forked from a saved version of a MutableTree, sharing its database
+// and node cache. Several branches can be changed independently, e.g. to execute candidate blocks
+// concurrently, and one of them can then be saved as the next version with
+// MutableTree.SaveBranch(). The base version can't be deleted until the branch is saved or
+// discarded.
+//
+// A TreeBranch is not safe for concurrent use, but different branches of the same tree may be
+// used concurrently with each other. The MutableTree must not be changed or saved meanwhile.
+type TreeBranch struct {
+	tree    *MutableTree // the working tree of the branch
+	release func()       // releases the base version, nil once the branch is saved or discarded
+}
+
+// Branch forks a new working tree from a saved version. The caller must either save it with
+// SaveBranch() or call Discard() when done.
+func (tree *MutableTree) Branch(version int64) (*TreeBranch, error) {
+	t, release, err := tree.getVersionForReading(version)
+	if err != nil {
+		return nil, err
+	}
+	return &TreeBranch{
+		tree: &MutableTree{
+			ImmutableTree: t,
+			lastSaved:     t.clone(),
+			orphans:       map[string]int64{},
+			changedKeys:   map[string]bool{},
+			versions:      map[int64]bool{},
+			ndb:           tree.ndb,
+		},
+		release: release,
+	}, nil
+}
+
+// SaveBranch saves a branch as the next version of the tree, discarding any changes to the
+// working tree. The branch must be based on the latest saved version, and can't be used
+// afterwards. Returns the hash and new version number.
+func (tree *MutableTree) SaveBranch(branch *TreeBranch) ([]byte, int64, error) {
+	if branch.release == nil {
+		return nil, 0, errors.New("branch was already saved or discarded")
+	}
+	if branch.tree.ndb != tree.ndb {
+		return nil, 0, errors.New("branch belongs to a different tree")
+	}
+	if branch.Version() != tree.version {
+		return nil, 0, errors.Errorf("branch is based on version %v, not the latest version %v",
+			branch.Version(), tree.version)
+	}
+	defer branch.Discard()
+
+	tree.resetChanges()
+	tree.ImmutableTree = branch.tree.ImmutableTree
+	tree.orphans = branch.tree.orphans
+	tree.changedKeys = branch.tree.changedKeys
+	return tree.SaveVersion()
+}
+
+// Version returns the version the branch is based on.
+func (b *TreeBranch) Version() int64 {
+	return b.tree.version
+}
+
+// Hash returns the root hash of the branch, including its changes.
+func (b *TreeBranch) Hash() []byte {
+	return b.tree.WorkingHash()
+}
+
+// Get returns the value of the specified key if it exists, or nil otherwise. The returned value
+// must not be modified, since it may point to data stored within IAVL.
+func (b *TreeBranch) Get(key []byte) []byte {
+	_, value := b.tree.Get(key)
+	return value
+}
+
+// Has returns whether or not a key exists.
+func (b *TreeBranch) Has(key []byte) bool {
+	return b.tree.Has(key)
+}
+
+// Set sets a key in the branch, like MutableTree.Set(). It returns true when an existing value was
+// updated, while false means it was a new key.
+func (b *TreeBranch) Set(key, value []byte) (updated bool) {
+	return b.tree.Set(key, value)
+}
+
+// Remove removes a key from the branch, and returns its value if it was removed.
+func (b *TreeBranch) Remove(key []byte) ([]byte, bool) {
+	return b.tree.Remove(key)
+}
+
+// Discard discards the branch and releases its base version. It is safe to call multiple times.
+func (b *TreeBranch) Discard() {
+	if b.release != nil {
+		b.release()
+		b.release = nil
+	}
+}
@@ -0,0 +1,120 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranch(t *testing.T) {
+	setup := func() *MutableTree {
+		tree, err := getTestTree(100)
+		require.NoError(t, err)
+		for v := 0; v < 2; v++ {
+			for i := 0; i < 200; i++ {
+				tree.Set([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("v%d", v)))
+			}
+			_, _, err = tree.SaveVersion()
+			require.NoError(t, err)
+		}
+		return tree
+	}
+	apply := func(seed int64, set func(key, value []byte), remove func(key []byte)) {
+		r := rand.New(rand.NewSource(seed))
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("k%03d", r.Intn(300)))
+			if r.Intn(3) == 0 {
+				remove(key)
+			} else {
+				set(key, []byte(fmt.Sprintf("b%d", i)))
+			}
+		}
+	}
+
+	tree := setup()
+	tree.Set([]byte("discarded"), []byte("x"))
+
+	// Branches are changed concurrently, and match the same changes applied to the tree.
+	branches := make([]*TreeBranch, 3)
+	for i := range branches {
+		b, err := tree.Branch(2)
+		require.NoError(t, err)
+		require.EqualValues(t, 2, b.Version())
+		branches[i] = b
+	}
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Add(1)
+		go func(seed int64, b *TreeBranch) {
+			defer wg.Done()
+			apply(seed, func(k, v []byte) { b.Set(k, v) }, func(k []byte) { b.Remove(k) })
+		}(int64(i), b)
+	}
+	wg.Wait()
+
+	for i, b := range branches {
+		expect := setup()
+		apply(int64(i), func(k, v []byte) { expect.Set(k, v) }, func(k []byte) { expect.Remove(k) })
+		require.Equal(t, expect.WorkingHash(), b.Hash())
+		_, value := expect.Get([]byte("k010"))
+		require.Equal(t, value, b.Get([]byte("k010")))
+		require.Equal(t, value != nil, b.Has([]byte("k010")))
+		require.Nil(t, b.Get([]byte("discarded")))
+	}
+
+	// The base version is protected while branches exist.
+	require.Error(t, tree.DeleteVersion(2))
+
+	// One branch is saved as the next version, and matches the tree with the same changes.
+	expect := setup()
+	apply(1, func(k, v []byte) { expect.Set(k, v) }, func(k []byte) { expect.Remove(k) })
+	expectHash, _, err := expect.SaveVersion()
+	require.NoError(t, err)
+
+	hash, version, err := tree.SaveBranch(branches[1])
+	require.NoError(t, err)
+	require.EqualValues(t, 3, version)
+	require.Equal(t, expectHash, hash)
+	require.False(t, tree.Has([]byte("discarded")))
+	_, _, err = tree.SaveBranch(branches[1])
+	require.Error(t, err)
+
+	// The other branches are now stale.
+	_, _, err = tree.SaveBranch(branches[0])
+	require.Error(t, err)
+	for _, b := range branches {
+		b.Discard()
+		b.Discard()
+	}
+	require.NoError(t, tree.DeleteVersion(2))
+
+	// The saved tree is persisted correctly.
+	tree.Set([]byte("k000"), []byte("new"))
+	expect.Set([]byte("k000"), []byte("new"))
+	hash, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	expectHash, _, err = expect.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, expectHash, hash)
+
+	// Branches can be forked from older versions, but not saved.
+	b, err := tree.Branch(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v0"), b.Get([]byte("k000")))
+	_, _, err = tree.SaveBranch(b)
+	require.Error(t, err)
+	b.Discard()
+
+	other := setup()
+	b, err = other.Branch(other.Version())
+	require.NoError(t, err)
+	_, _, err = tree.SaveBranch(b)
+	require.Error(t, err)
+	b.Discard()
+
+	_, err = tree.Branch(2)
+	require.Error(t, err)
+}
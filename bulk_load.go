@@ -0,0 +1,274 @@
+package iavl
+
+import (
+	"bytes"
+	"container/list"
+	"math"
+
+	"github.com/pkg/errors"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// BulkLoad builds the initial version of an empty tree from an iterator over key/value pairs in
+// strictly increasing key order, e.g. a tm-db Iterator, which is much faster than calling Set()
+// for every pair. The version is Options.InitialVersion, or 1 if not set. Returns the hash and
+// version number. The iterator is not closed.
+//
+// The tree is built bottom-up as the pairs are read, with all subtrees but the rightmost ones
+// complete, and has the minimal height for the number of keys. Completed nodes are flushed to the
+// database in batches, so only O(log² n) nodes are held in memory. If the input is not sorted, or
+// the iterator fails, an error is returned, the nodes already flushed are deleted again, and the
+// tree and database are left empty.
+func (tree *MutableTree) BulkLoad(itr dbm.Iterator) ([]byte, int64, error) {
+	if tree.ndb.getLatestVersion() > 0 {
+		return nil, 0, errors.Errorf("found database at version %d, must be 0", tree.ndb.latestVersion)
+	}
+	if !tree.IsEmpty() {
+		return nil, 0, errors.New("tree must be empty")
+	}
+	version := int64(1)
+	if tree.ndb.opts.InitialVersion > 0 {
+		version = int64(tree.ndb.opts.InitialVersion)
+	}
+
+	l := &bulkLoader{ndb: tree.ndb, version: version}
+	root, err := l.load(itr)
+	if err != nil {
+		if derr := l.discard(); derr != nil {
+			return nil, 0, errors.Wrapf(derr, "discarding tree after failed load (%v)", err)
+		}
+		return nil, 0, err
+	}
+
+	if root == nil {
+		err = tree.ndb.SaveEmptyRoot(version)
+	} else if err = l.save(root); err == nil {
+		err = tree.ndb.SaveRoot(root, version)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = tree.ndb.Commit(); err != nil {
+		return nil, 0, err
+	}
+	if _, err = tree.LoadVersion(version); err != nil {
+		return nil, 0, err
+	}
+	return tree.Hash(), version, nil
+}
+
+// bulkLoader builds a tree from sorted leaves. Like a binary counter, it keeps at most one
+// pending subtree of each height, and two subtrees of the same height are joined under a new
+// parent as soon as both are complete. The left child of a parent never changes afterwards, so it
+// is saved right away, and only the right spines of the pending subtrees remain in memory.
+type bulkLoader struct {
+	ndb       *nodeDB
+	version   int64
+	pending   []*Node  // pending perfect subtrees, by height
+	mins      [][]byte // the leftmost keys of the pending subtrees
+	batchSize int
+
+	historyStarted bool // the start of the key history index has been written
+}
+
+// load reads all pairs from the iterator, and returns the unsaved root of the tree.
+func (l *bulkLoader) load(itr dbm.Iterator) (*Node, error) {
+	var prev []byte
+	for ; itr.Valid(); itr.Next() {
+		key, value := itr.Key(), itr.Value()
+		if value == nil {
+			return nil, errors.Errorf("value for key %X cannot be nil", key)
+		}
+		if prev != nil && bytes.Compare(key, prev) <= 0 {
+			return nil, errors.Errorf("keys must be in strictly increasing order, got %X after %X",
+				key, prev)
+		}
+		// The iterator may reuse its buffers.
+		key = append([]byte{}, key...)
+		value = append([]byte{}, value...)
+		prev = key
+		if err := l.add(key, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+
+	// Join the pending subtrees from the smallest up. Each join descends the right spine of the
+	// larger subtree, which is still in memory.
+	var root *Node
+	var rootMin []byte
+	for height, node := range l.pending {
+		if node == nil {
+			continue
+		}
+		if root != nil {
+			root = l.join(node, root, rootMin)
+		} else {
+			root = node
+		}
+		rootMin = l.mins[height]
+	}
+	return root, nil
+}
+
+// add adds the next leaf, joining it with the pending subtrees of the same height.
+func (l *bulkLoader) add(key, value []byte) error {
+	node, min := NewNode(key, value, l.version), key
+	for height := 0; ; height++ {
+		if height == len(l.pending) {
+			l.pending = append(l.pending, nil)
+			l.mins = append(l.mins, nil)
+		}
+		left := l.pending[height]
+		if left == nil {
+			l.pending[height], l.mins[height] = node, min
+			return nil
+		}
+		if err := l.save(left); err != nil {
+			return err
+		}
+		node, min = l.newInner(left, node, min), l.mins[height]
+		l.pending[height], l.mins[height] = nil, nil
+	}
+}
+
+// join joins a perfect subtree with a shorter subtree of greater keys, with the given leftmost
+// key, by descending the right spine of the left subtree. Since all subtrees along the spine are
+// perfect, the result is balanced without rotations.
+func (l *bulkLoader) join(left, right *Node, rightMin []byte) *Node {
+	if left.height <= right.height+1 {
+		return l.newInner(left, right, rightMin)
+	}
+	left.rightNode = l.join(left.rightNode, right, rightMin)
+	left.height = maxInt8(left.leftNode.height, left.rightNode.height) + 1
+	left.size += right.size
+	return left
+}
+
+func (l *bulkLoader) newInner(left, right *Node, rightMin []byte) *Node {
+	return &Node{
+		key:       rightMin,
+		version:   l.version,
+		height:    maxInt8(left.height, right.height) + 1,
+		size:      left.size + right.size,
+		leftNode:  left,
+		rightNode: right,
+	}
+}
+
+// discard discards the nodes of the tree, both unflushed and already flushed, along with any key
+// history entries. The database has no versions, so every node in it belongs to the tree.
+func (l *bulkLoader) discard() error {
+	ndb := l.ndb
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.batch.Close()
+	ndb.batch = ndb.db.NewBatch()
+	ndb.nodeCache = make(map[string]*list.Element)
+	ndb.nodeCacheQueue = list.New()
+
+	// Delete the nodes in chunks, closing the iterator before writing each one, since some
+	// databases don't allow writes while an iterator is open.
+	for {
+		deleted, err := l.deleteNodes(maxBatchSize)
+		if err != nil {
+			return err
+		}
+		if deleted < maxBatchSize {
+			break
+		}
+		if err = l.flushLocked(); err != nil {
+			return err
+		}
+	}
+
+	if ndb.opts.KeyHistory {
+		ndb.deleteKeyChangesFrom(l.version)
+	}
+	return l.flushLocked()
+}
+
+// deleteNodes adds the deletion of up to limit nodes to the batch, and returns how many there were.
+// The caller must hold the nodeDB mutex.
+func (l *bulkLoader) deleteNodes(limit int) (int, error) {
+	itr, err := dbm.IteratePrefix(l.ndb.db, nodeKeyFormat.Key())
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+	deleted := 0
+	for ; itr.Valid() && deleted < limit; itr.Next() {
+		if err = l.ndb.batch.Delete(itr.Key()); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, itr.Error()
+}
+
+// flushLocked writes the batch and starts a new one. The caller must hold the nodeDB mutex.
+func (l *bulkLoader) flushLocked() error {
+	err := l.ndb.batch.Write()
+	l.ndb.batch.Close()
+	l.ndb.batch = l.ndb.db.NewBatch()
+	return err
+}
+
+// save saves the unsaved nodes of a subtree, flushing the batch when it is full. The child
+// pointers of saved nodes are cleared, so they can be freed.
+func (l *bulkLoader) save(node *Node) error {
+	if node.persisted {
+		return nil
+	}
+	if node.leftNode != nil {
+		if err := l.save(node.leftNode); err != nil {
+			return err
+		}
+		node.leftHash = node.leftNode.hash
+	}
+	if node.rightNode != nil {
+		if err := l.save(node.rightNode); err != nil {
+			return err
+		}
+		node.rightHash = node.rightNode.hash
+	}
+	node._hash()
+	l.ndb.SaveNode(node)
+	node.leftNode = nil
+	node.rightNode = nil
+
+	if node.isLeaf() && l.ndb.opts.KeyHistory {
+		if err := l.saveKeyChange(node); err != nil {
+			return err
+		}
+	}
+
+	l.batchSize++
+	if l.batchSize >= maxBatchSize {
+		l.batchSize = 0
+		return l.ndb.Commit()
+	}
+	return nil
+}
+
+// saveKeyChange adds a leaf to the key history index. There are no earlier versions, so the entry
+// doesn't replace any other, and is written straight to the batch without looking up the index.
+func (l *bulkLoader) saveKeyChange(leaf *Node) error {
+	l.ndb.mtx.Lock()
+	defer l.ndb.mtx.Unlock()
+	if !l.historyStarted {
+		// The index covers all versions, since there are none before this one.
+		bz := keyHistoryStartValue(l.version, true)
+		if err := l.ndb.batch.Set(keyHistoryStartKeyFormat.Key(), bz); err != nil {
+			return err
+		}
+		l.historyStarted = true
+	}
+	if err := l.ndb.batch.Set(keyHistoryKey(leaf.key, l.version), keyHistoryValue(leaf.value)); err != nil {
+		return err
+	}
+	return l.ndb.batch.Set(keyLifetimeKey(math.MaxInt64, l.version, leaf.key), []byte{})
+}
@@ -0,0 +1,137 @@
+package iavl
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+func TestMutableTree_BulkLoad(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 5, 8, 13, 100, 1000, maxBatchSize*2 + 3} {
+		n := n
+		t.Run(fmt.Sprintf("%v", n), func(t *testing.T) {
+			source := db.NewMemDB()
+			for i := 0; i < n; i++ {
+				require.NoError(t, source.Set([]byte(fmt.Sprintf("k%08d", i)), []byte(fmt.Sprintf("v%v", i))))
+			}
+			itr, err := source.Iterator(nil, nil)
+			require.NoError(t, err)
+			defer itr.Close()
+
+			memDB := db.NewMemDB()
+			tree, err := NewMutableTree(memDB, 0)
+			require.NoError(t, err)
+			hash, version, err := tree.BulkLoad(itr)
+			require.NoError(t, err)
+			require.EqualValues(t, 1, version)
+			require.Equal(t, hash, tree.Hash())
+
+			// The tree is balanced with minimal height, and can be loaded from the database.
+			tree, err = NewMutableTree(memDB, 0)
+			require.NoError(t, err)
+			_, err = tree.Load()
+			require.NoError(t, err)
+			require.Equal(t, hash, tree.Hash())
+			require.EqualValues(t, n, tree.Size())
+			if n > 0 {
+				require.EqualValues(t, bits.Len(uint(n-1)), tree.Height())
+				requireBalanced(t, tree.ImmutableTree, tree.root)
+			}
+			for i := 0; i < n; i++ {
+				_, value := tree.Get([]byte(fmt.Sprintf("k%08d", i)))
+				require.Equal(t, []byte(fmt.Sprintf("v%v", i)), value)
+			}
+
+			tree.Set([]byte("k"), []byte("v"))
+			_, version, err = tree.SaveVersion()
+			require.NoError(t, err)
+			require.EqualValues(t, 2, version)
+		})
+	}
+}
+
+func TestMutableTree_BulkLoad_Options(t *testing.T) {
+	source := db.NewMemDB()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, source.Set([]byte(key), []byte(key+key)))
+	}
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(memDB, 0, &Options{InitialVersion: 10, KeyHistory: true})
+	require.NoError(t, err)
+
+	// Unsorted input is rejected, leaving the tree empty.
+	itr, err := source.ReverseIterator(nil, nil)
+	require.NoError(t, err)
+	_, _, err = tree.BulkLoad(itr)
+	require.Error(t, err)
+	itr.Close()
+	require.True(t, tree.IsEmpty())
+	require.Empty(t, tree.AvailableVersions())
+
+	itr, err = source.Iterator(nil, nil)
+	require.NoError(t, err)
+	_, version, err := tree.BulkLoad(itr)
+	require.NoError(t, err)
+	itr.Close()
+	require.EqualValues(t, 10, version)
+	require.Equal(t, []int{10}, tree.AvailableVersions())
+	history, err := tree.KeyHistory([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, []KeyChange{{Version: 10, Value: []byte("cc")}}, history)
+	requireKeyHistory(t, tree, [][]byte{[]byte("a"), []byte("e"), []byte("f")})
+
+	// Loading requires an empty tree.
+	itr, err = source.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+	_, _, err = tree.BulkLoad(itr)
+	require.Error(t, err)
+}
+
+// failingIterator is an iterator that fails once it is exhausted.
+type failingIterator struct {
+	db.Iterator
+}
+
+func (itr failingIterator) Error() error {
+	if itr.Valid() {
+		return nil
+	}
+	return errors.New("iterator failed")
+}
+
+func TestMutableTree_BulkLoad_Discard(t *testing.T) {
+	source := db.NewMemDB()
+	for i := 0; i < maxBatchSize*3+7; i++ {
+		require.NoError(t, source.Set([]byte(fmt.Sprintf("k%08d", i)), []byte(fmt.Sprintf("v%v", i))))
+	}
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(memDB, 0, &Options{KeyHistory: true})
+	require.NoError(t, err)
+
+	// The nodes and key history entries flushed before the failure are deleted again.
+	itr, err := source.Iterator(nil, nil)
+	require.NoError(t, err)
+	_, _, err = tree.BulkLoad(failingIterator{itr})
+	require.Error(t, err)
+	itr.Close()
+	require.True(t, tree.IsEmpty())
+	dbItr, err := memDB.Iterator(nil, nil)
+	require.NoError(t, err)
+	require.False(t, dbItr.Valid())
+	dbItr.Close()
+
+	// The tree can still be loaded afterwards.
+	itr, err = source.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+	_, version, err := tree.BulkLoad(itr)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, version)
+	require.EqualValues(t, maxBatchSize*3+7, tree.Size())
+}
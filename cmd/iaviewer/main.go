@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -20,8 +21,13 @@ const (
 
 func main() {
 	args := os.Args[1:]
+	if len(args) >= 1 && args[0] == "diff-db" {
+		diffMain(args[1:])
+		return
+	}
 	if len(args) < 2 || (args[0] != "data" && args[0] != "shape" && args[0] != "versions") {
 		fmt.Fprintln(os.Stderr, "Usage: iaviewer <data|shape|versions> <leveldb dir> [version number]")
+		fmt.Fprintln(os.Stderr, "       iaviewer diff-db <leveldb dir> <leveldb dir> [version number [version number]]")
 		os.Exit(1)
 	}
 
@@ -53,6 +59,57 @@ func main() {
 	}
 }
 
+// diffMain compares two trees, either in two databases or at two versions of the same database,
+// and prints the first difference.
+func diffMain(args []string) {
+	if len(args) < 2 || len(args) > 4 {
+		fmt.Fprintln(os.Stderr, "Usage: iaviewer diff-db <leveldb dir> <leveldb dir> [version number [version number]]")
+		os.Exit(1)
+	}
+	versions := []int{0, 0}
+	for i, arg := range args[2:] {
+		var err error
+		versions[i], err = strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid version number: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(args) == 3 {
+		versions[1] = versions[0]
+	}
+
+	treeA, err := ReadTree(args[0], versions[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading data: %s\n", err)
+		os.Exit(1)
+	}
+	var treeB *iavl.ImmutableTree
+	if filepath.Clean(args[1]) != filepath.Clean(args[0]) {
+		var tree *iavl.MutableTree
+		tree, err = ReadTree(args[1], versions[1])
+		if tree != nil {
+			treeB = tree.ImmutableTree
+		}
+	} else {
+		// The same database can't be opened twice, so load the other version from the same tree.
+		if versions[1] == 0 {
+			available := treeA.AvailableVersions()
+			if len(available) == 0 {
+				fmt.Fprintf(os.Stderr, "No versions found in %s\n", args[0])
+				os.Exit(1)
+			}
+			versions[1] = available[len(available)-1]
+		}
+		treeB, err = treeA.GetImmutable(int64(versions[1]))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading data: %s\n", err)
+		os.Exit(1)
+	}
+	PrintDiff(treeA.ImmutableTree, treeB)
+}
+
 func OpenDB(dir string) (dbm.DB, error) {
 	switch {
 	case strings.HasSuffix(dir, ".db"):
@@ -164,6 +221,35 @@ func nodeEncoder(id []byte, depth int, isLeaf bool) string {
 	return fmt.Sprintf("%s%s", prefix, parseWeaveKey(id))
 }
 
+// PrintDiff prints the first difference between the hashed nodes of two trees.
+func PrintDiff(treeA, treeB *iavl.ImmutableTree) {
+	diff := iavl.DiffTrees(treeA, treeB)
+	if diff == nil {
+		fmt.Printf("Trees are identical, hash: %X\n", treeA.Hash())
+		return
+	}
+	fmt.Printf("Hash A: %X\n", treeA.Hash())
+	fmt.Printf("Hash B: %X\n", treeB.Hash())
+	fmt.Printf("Path: %q\n", diff.Path)
+	fmt.Printf("Differing fields: %s\n", strings.Join(diff.Fields, ", "))
+	printDiffNode("A", diff.A, len(diff.Path))
+	printDiffNode("B", diff.B, len(diff.Path))
+}
+
+func printDiffNode(name string, node *iavl.DiffNode, depth int) {
+	if node == nil {
+		fmt.Printf("%s: <empty tree>\n", name)
+		return
+	}
+	fmt.Printf("%s: %s\n", name, nodeEncoder(node.Key, depth, node.Height == 0))
+	fmt.Printf("  version: %d, height: %d, size: %d\n", node.Version, node.Height, node.Size)
+	fmt.Printf("  hash: %X\n", node.Hash)
+	if node.Height == 0 {
+		digest := sha256.Sum256(node.Value)
+		fmt.Printf("  value hash: %X\n", digest)
+	}
+}
+
 func PrintVersions(tree *iavl.MutableTree) {
 	versions := tree.AvailableVersions()
 	fmt.Println("Available versions:")
@@ -0,0 +1,88 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Condition is a precondition of CheckAndMutate on the value of a key, with a nil value meaning
+// that the key must be absent.
+type Condition struct {
+	Key   []byte
+	Value []byte
+}
+
+// Mutation is a change applied by CheckAndMutate, which sets a key to a value, or removes it if
+// the value is nil.
+type Mutation struct {
+	Key   []byte
+	Value []byte
+}
+
+// ConditionFailedError is returned by conditional writes when a key does not have the expected
+// value. Values are nil for keys that are absent.
+type ConditionFailedError struct {
+	Key      []byte
+	Expected []byte
+	Actual   []byte
+}
+
+// Error implements error.
+func (e *ConditionFailedError) Error() string {
+	return fmt.Sprintf("condition failed for key %X: expected %v, found %v",
+		e.Key, formatConditionValue(e.Expected), formatConditionValue(e.Actual))
+}
+
+func formatConditionValue(value []byte) string {
+	if value == nil {
+		return "no value"
+	}
+	return fmt.Sprintf("value %X", value)
+}
+
+// CompareAndSet sets a key to a new value if its current value in the working tree is the expected
+// one, with a nil expected value meaning that the key must be absent. Otherwise, it returns a
+// *ConditionFailedError and leaves the tree unchanged. Nil new values are invalid.
+//
+// Like other MutableTree methods, it is not safe for concurrent use: writers sharing a tree must
+// serialize their calls, but may read values and compute new ones optimistically in between.
+func (tree *MutableTree) CompareAndSet(key, expected, value []byte) error {
+	if err := tree.checkCondition(Condition{Key: key, Value: expected}); err != nil {
+		return err
+	}
+	tree.Set(key, value)
+	return nil
+}
+
+// SetIfAbsent sets a key to a value if it does not exist in the working tree. Otherwise, it returns
+// a *ConditionFailedError and leaves the tree unchanged.
+func (tree *MutableTree) SetIfAbsent(key, value []byte) error {
+	return tree.CompareAndSet(key, nil, value)
+}
+
+// CheckAndMutate checks that all conditions hold in the working tree, and if so applies the
+// mutations in order. Otherwise, it returns a *ConditionFailedError for the first condition that
+// does not hold, and applies none of the mutations.
+func (tree *MutableTree) CheckAndMutate(conditions []Condition, mutations []Mutation) error {
+	for _, condition := range conditions {
+		if err := tree.checkCondition(condition); err != nil {
+			return err
+		}
+	}
+	for _, mutation := range mutations {
+		if mutation.Value == nil {
+			tree.Remove(mutation.Key)
+		} else {
+			tree.Set(mutation.Key, mutation.Value)
+		}
+	}
+	return nil
+}
+
+func (tree *MutableTree) checkCondition(condition Condition) error {
+	_, value := tree.ImmutableTree.Get(condition.Key)
+	if (value == nil) != (condition.Value == nil) || !bytes.Equal(value, condition.Value) {
+		return &ConditionFailedError{Key: condition.Key, Expected: condition.Value, Actual: value}
+	}
+	return nil
+}
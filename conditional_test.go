@@ -0,0 +1,49 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutableTree_ConditionalWrites(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+
+	require.NoError(t, tree.SetIfAbsent([]byte("a"), []byte("1")))
+	err = tree.SetIfAbsent([]byte("a"), []byte("2"))
+	require.Equal(t, &ConditionFailedError{Key: []byte("a"), Actual: []byte("1")}, err)
+
+	require.NoError(t, tree.CompareAndSet([]byte("a"), []byte("1"), []byte("2")))
+	err = tree.CompareAndSet([]byte("a"), []byte("1"), []byte("3"))
+	require.Equal(t, &ConditionFailedError{Key: []byte("a"), Expected: []byte("1"), Actual: []byte("2")}, err)
+	err = tree.CompareAndSet([]byte("b"), []byte("1"), []byte("3"))
+	require.Equal(t, &ConditionFailedError{Key: []byte("b"), Expected: []byte("1")}, err)
+	require.EqualError(t, err, "condition failed for key 62: expected value 31, found no value")
+
+	// An empty value is not the same as an absent key.
+	require.NoError(t, tree.SetIfAbsent([]byte("e"), []byte{}))
+	require.Error(t, tree.CompareAndSet([]byte("e"), nil, []byte("1")))
+	require.NoError(t, tree.CompareAndSet([]byte("e"), []byte{}, []byte("1")))
+
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	hash := tree.WorkingHash()
+
+	// Failed conditions name the first mismatched key, and change nothing.
+	err = tree.CheckAndMutate(
+		[]Condition{{Key: []byte("a"), Value: []byte("2")}, {Key: []byte("c")}, {Key: []byte("e")}, {Key: []byte("a")}},
+		[]Mutation{{Key: []byte("c"), Value: []byte("1")}, {Key: []byte("a")}},
+	)
+	require.Equal(t, &ConditionFailedError{Key: []byte("e"), Actual: []byte("1")}, err)
+	require.Equal(t, hash, tree.WorkingHash())
+
+	err = tree.CheckAndMutate(
+		[]Condition{{Key: []byte("a"), Value: []byte("2")}, {Key: []byte("c")}},
+		[]Mutation{{Key: []byte("c"), Value: []byte("1")}, {Key: []byte("a")}, {Key: []byte("c"), Value: []byte("2")}},
+	)
+	require.NoError(t, err)
+	require.False(t, tree.Has([]byte("a")))
+	_, value := tree.Get([]byte("c"))
+	require.Equal(t, []byte("2"), value)
+}
@@ -0,0 +1,116 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// TreeDiff is the first structural difference between two trees, as found by DiffTrees.
+type TreeDiff struct {
+	// Path is the sequence of child hashes followed from the roots to the diverging nodes, as
+	// "L" for the left hash and "R" for the right hash of each inner node. At every step, the
+	// left hash is followed if it differs.
+	Path string
+	// Fields lists the hashed fields that differ between the diverging nodes, in the order they
+	// are hashed by the node: "height", "size", "version", "key" and "value". It is "root" when
+	// only one of the trees is empty.
+	Fields []string
+	// A and B are the diverging nodes, or nil for an empty tree.
+	A, B *DiffNode
+}
+
+// DiffNode describes a node of a TreeDiff.
+type DiffNode struct {
+	Hash    []byte
+	Key     []byte // for inner nodes, the leftmost key of the right subtree, which is not hashed
+	Value   []byte // nil for inner nodes
+	Version int64
+	Height  int8
+	Size    int64
+}
+
+// DiffTrees walks two trees from the root, descending only into children with different hashes,
+// and returns the first difference of the hashed node fields. It returns nil if the trees have
+// the same root hash. It is intended for debugging hash mismatches, e.g. between the same version
+// of a tree in two databases, or between two versions.
+func DiffTrees(a, b *ImmutableTree) *TreeDiff {
+	hashA, hashB := a.Hash(), b.Hash()
+	switch {
+	case bytes.Equal(hashA, hashB):
+		return nil
+	case a.root == nil || b.root == nil:
+		return &TreeDiff{Fields: []string{"root"}, A: newDiffNode(a.root), B: newDiffNode(b.root)}
+	}
+
+	var path strings.Builder
+	nodeA, nodeB := a.root, b.root
+	for nodeA.height == nodeB.height && !nodeA.isLeaf() {
+		if !bytes.Equal(nodeA.leftHash, nodeB.leftHash) {
+			path.WriteByte('L')
+			nodeA, nodeB = nodeA.getLeftNode(a), nodeB.getLeftNode(b)
+		} else if !bytes.Equal(nodeA.rightHash, nodeB.rightHash) {
+			path.WriteByte('R')
+			nodeA, nodeB = nodeA.getRightNode(a), nodeB.getRightNode(b)
+		} else {
+			break
+		}
+	}
+	return &TreeDiff{
+		Path:   path.String(),
+		Fields: diffFields(nodeA, nodeB),
+		A:      newDiffNode(nodeA),
+		B:      newDiffNode(nodeB),
+	}
+}
+
+// diffFields returns the hashed fields that differ between two nodes, other than child hashes.
+func diffFields(a, b *Node) []string {
+	fields := []string{}
+	if a.height != b.height {
+		fields = append(fields, "height")
+	}
+	if a.size != b.size {
+		fields = append(fields, "size")
+	}
+	if a.version != b.version {
+		fields = append(fields, "version")
+	}
+	if a.isLeaf() && b.isLeaf() {
+		if !bytes.Equal(a.key, b.key) {
+			fields = append(fields, "key")
+		}
+		if !bytes.Equal(a.value, b.value) {
+			fields = append(fields, "value")
+		}
+	}
+	return fields
+}
+
+func newDiffNode(node *Node) *DiffNode {
+	if node == nil {
+		return nil
+	}
+	return &DiffNode{
+		Hash:    node.hash,
+		Key:     node.key,
+		Value:   node.value,
+		Version: node.version,
+		Height:  node.height,
+		Size:    node.size,
+	}
+}
+
+// String implements fmt.Stringer.
+func (d *TreeDiff) String() string {
+	return fmt.Sprintf("TreeDiff{path:%q fields:%v\n  A:%v\n  B:%v\n}", d.Path, d.Fields, d.A, d.B)
+}
+
+// String implements fmt.Stringer.
+func (n *DiffNode) String() string {
+	if n == nil {
+		return "<empty>"
+	}
+	return fmt.Sprintf("DiffNode{key:%X value:%X version:%d height:%d size:%d hash:%X}",
+		n.Key, n.Value, n.Version, n.Height, n.Size, n.Hash)
+}
@@ -0,0 +1,64 @@
+package iavl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTrees(t *testing.T) {
+	newTree := func(fn func(tree *MutableTree)) *ImmutableTree {
+		tree, err := getTestTree(0)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			tree.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+		}
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+		fn(tree)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+		return tree.ImmutableTree
+	}
+
+	tree := newTree(func(tree *MutableTree) {})
+	require.Nil(t, DiffTrees(tree, newTree(func(tree *MutableTree) {})))
+
+	// A different value is found at the leaf.
+	diff := DiffTrees(tree, newTree(func(tree *MutableTree) {
+		tree.Set([]byte("k042"), []byte("x"))
+	}))
+	require.NotNil(t, diff)
+	require.Equal(t, []string{"version", "value"}, diff.Fields)
+	require.Equal(t, []byte("k042"), diff.A.Key)
+	require.Equal(t, []byte("v"), diff.A.Value)
+	require.Equal(t, []byte("x"), diff.B.Value)
+	require.Equal(t, int64(1), diff.A.Version)
+	require.Equal(t, int64(2), diff.B.Version)
+	require.NotEmpty(t, diff.Path)
+
+	// Setting the same value still changes the version.
+	diff = DiffTrees(tree, newTree(func(tree *MutableTree) {
+		tree.Set([]byte("k000"), []byte("v"))
+	}))
+	require.Equal(t, []string{"version"}, diff.Fields)
+	require.Equal(t, []byte("k000"), diff.A.Key)
+	require.Regexp(t, "^L+$", diff.Path)
+
+	// An added key changes the shape of the tree.
+	diff = DiffTrees(tree, newTree(func(tree *MutableTree) {
+		tree.Set([]byte("k999"), []byte("v"))
+	}))
+	require.Contains(t, diff.Fields, "height")
+	require.NotEqual(t, diff.A.Height, diff.B.Height)
+
+	// An empty tree differs at the root.
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	diff = DiffTrees(tree, empty.ImmutableTree)
+	require.Equal(t, []string{"root"}, diff.Fields)
+	require.NotNil(t, diff.A)
+	require.Nil(t, diff.B)
+	require.Nil(t, DiffTrees(empty.ImmutableTree, empty.ImmutableTree))
+}
@@ -17,6 +17,7 @@ type ImmutableTree struct {
 	root    *Node
 	ndb     *nodeDB
 	version int64
+	witness *witnessRecorder // records node accesses, if not nil
 }
 
 // NewImmutableTree creates both in-memory and persistent instances
@@ -160,6 +161,52 @@ func (t *ImmutableTree) GetByIndex(index int64) (key []byte, value []byte) {
 	return t.root.getByIndex(t, index)
 }
 
+// Rank returns the number of keys less than the given key, i.e. its index if it exists. It is
+// computed from the subtree sizes in a single descent.
+func (t *ImmutableTree) Rank(key []byte) int64 {
+	if t.root == nil {
+		return 0
+	}
+	index, _ := t.root.get(t, key)
+	return index
+}
+
+// CountRange returns the number of keys in the range [start, end), without iterating over them.
+// Either bound may be nil, in which case the range is open on that side. It returns 0 if start is
+// after end.
+func (t *ImmutableTree) CountRange(start, end []byte) int64 {
+	startIndex, endIndex := int64(0), t.Size()
+	if start != nil {
+		startIndex = t.Rank(start)
+	}
+	if end != nil {
+		endIndex = t.Rank(end)
+	}
+	if endIndex < startIndex {
+		return 0
+	}
+	return endIndex - startIndex
+}
+
+// Select returns the key and value at the given percentile, between 0 and 1, of the keys in
+// order, i.e. at index floor(percentile * (size - 1)). It returns nil for an empty tree or a
+// percentile out of range.
+func (t *ImmutableTree) Select(percentile float64) (key []byte, value []byte) {
+	if t.root == nil || !(percentile >= 0 && percentile <= 1) {
+		return nil, nil
+	}
+	return t.root.getByIndex(t, int64(percentile*float64(t.root.size-1)))
+}
+
+// Median returns the key and value of the median key, or of the lower one for an even number of
+// keys. It returns nil for an empty tree.
+func (t *ImmutableTree) Median() (key []byte, value []byte) {
+	if t.root == nil {
+		return nil, nil
+	}
+	return t.root.getByIndex(t, (t.root.size-1)/2)
+}
+
 // Iterate iterates over all keys of the tree, in order. The keys and values must not be modified,
 // since they may point to data stored within IAVL.
 func (t *ImmutableTree) Iterate(fn func(key []byte, value []byte) bool) (stopped bool) {
@@ -204,6 +251,18 @@ func (t *ImmutableTree) IterateRangeInclusive(start, end []byte, ascending bool,
 	})
 }
 
+// IterateChangedSince iterates in order over the keys that were set after the given version, along
+// with the version at which they were last set. Subtrees that were not changed since the version
+// are skipped, without loading their descendants. Keys that were removed are not visited, while keys
+// that were set to their existing value are. The keys and values must not be modified, since they
+// may point to data stored within IAVL.
+func (t *ImmutableTree) IterateChangedSince(version int64, fn func(key, value []byte, version int64) bool) (stopped bool) {
+	if t.root == nil {
+		return false
+	}
+	return t.root.traverseChangedSince(t, version, fn)
+}
+
 // Clone creates a clone of the tree.
 // Used internally by MutableTree.
 func (t *ImmutableTree) clone() *ImmutableTree {
@@ -211,6 +270,7 @@ func (t *ImmutableTree) clone() *ImmutableTree {
 		root:    t.root,
 		ndb:     t.ndb,
 		version: t.version,
+		witness: t.witness,
 	}
 }
 
@@ -0,0 +1,407 @@
+package iavl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// KeyChange is a change of a key, as recorded by the key history index. See Options.KeyHistory.
+type KeyChange struct {
+	Version int64  // the first version with the new value
+	Value   []byte // the new value, or nil if the key was removed
+}
+
+// KeyHistory returns the changes of a key recorded by the key history index, in ascending version
+// order, for the versions that still exist. The index must be enabled via Options.KeyHistory. If
+// it was enabled after versions had already been saved, earlier changes are not included.
+func (tree *MutableTree) KeyHistory(key []byte) ([]KeyChange, error) {
+	start, complete, err := tree.ndb.getKeyHistoryStart()
+	if err != nil {
+		return nil, err
+	}
+	if start == 0 {
+		return nil, errors.New("key history index is not enabled")
+	}
+	return tree.ndb.getKeyChanges(key, start, complete)
+}
+
+// GetVersionedValue gets the value at the specified key and version, like GetVersioned() but
+// without the position of the key, and returning an error if the version doesn't exist or can't be
+// read. When the version is covered by the key history index (see Options.KeyHistory), the value
+// is read from the index with a single seek instead of loading the tree at that version. The
+// returned value must not be modified, since it may point to data stored within IAVL.
+func (tree *MutableTree) GetVersionedValue(key []byte, version int64) ([]byte, error) {
+	if !tree.VersionExists(version) {
+		return nil, ErrVersionDoesNotExist
+	}
+	if tree.ndb.opts.KeyHistory {
+		value, ok, err := tree.ndb.getKeyHistoryValue(key, version)
+		if err != nil || ok {
+			return value, err
+		}
+	}
+	t, err := tree.GetImmutable(version)
+	if err != nil {
+		return nil, err
+	}
+	_, value := t.Get(key)
+	return value, nil
+}
+
+// saveKeyHistory adds the keys changed in the working tree to the key history index, if enabled.
+func (tree *MutableTree) saveKeyHistory(version int64) error {
+	if !tree.ndb.opts.KeyHistory {
+		return tree.ndb.resetKeyHistory()
+	}
+	keys := make([]string, 0, len(tree.changedKeys))
+	for key := range tree.changedKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]KeyChange, 0, len(keys))
+	changedKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		_, value := tree.ImmutableTree.Get([]byte(key))
+		_, prev := tree.lastSaved.Get([]byte(key))
+		if (value == nil) == (prev == nil) && bytes.Equal(value, prev) {
+			continue
+		}
+		changedKeys = append(changedKeys, []byte(key))
+		changes = append(changes, KeyChange{Version: version, Value: value})
+	}
+	return tree.ndb.SaveKeyChanges(version, changedKeys, changes)
+}
+
+// SaveKeyChanges adds changes of the given keys at a version to the key history index, starting
+// the index if necessary.
+func (ndb *nodeDB) SaveKeyChanges(version int64, keys [][]byte, changes []KeyChange) error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+
+	start, _, err := ndb.getKeyHistoryStart()
+	if err != nil {
+		return err
+	}
+	predecessor := ndb.getPreviousVersion(version)
+	if start == 0 {
+		// The index covers all versions if there are none before it.
+		bz := keyHistoryStartValue(version, predecessor == 0)
+		if err = ndb.batch.Set(keyHistoryStartKeyFormat.Key(), bz); err != nil {
+			return err
+		}
+	}
+
+	for i, key := range keys {
+		// The current entry of the key, if any, is now only needed up to the previous version.
+		prev, _, ok, err := ndb.getKeyChange(key, version-1)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err = ndb.batch.Delete(keyLifetimeKey(math.MaxInt64, prev, key)); err != nil {
+				return err
+			}
+			if prev > predecessor {
+				err = ndb.batch.Delete(keyHistoryKey(key, prev))
+			} else {
+				err = ndb.batch.Set(keyLifetimeKey(predecessor, prev, key), []byte{})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err = ndb.batch.Set(keyHistoryKey(key, version), keyHistoryValue(changes[i].Value)); err != nil {
+			return err
+		}
+		if err = ndb.batch.Set(keyLifetimeKey(math.MaxInt64, version, key), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetKeyHistory discards the key history index, since it no longer covers the latest changes.
+// Existing entries predate any later index, which ignores them. The index can't be started again
+// while it is disabled, so this only needs to be done once per nodeDB.
+func (ndb *nodeDB) resetKeyHistory() error {
+	if ndb.keyHistoryReset {
+		return nil
+	}
+	has, err := ndb.db.Has(keyHistoryStartKeyFormat.Key())
+	if err != nil {
+		return err
+	}
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	if has {
+		if err := ndb.batch.Delete(keyHistoryStartKeyFormat.Key()); err != nil {
+			return err
+		}
+	}
+	ndb.keyHistoryReset = true
+	return nil
+}
+
+// keyHistoryStartValue encodes the first version covered by the key history index, and whether it
+// covers all versions.
+func keyHistoryStartValue(version int64, complete bool) []byte {
+	bz := make([]byte, int64Size+1)
+	binary.BigEndian.PutUint64(bz, uint64(version))
+	if complete {
+		bz[int64Size] = 1
+	}
+	return bz
+}
+
+// getKeyHistoryStart returns the first version covered by the key history index, or 0 if there is
+// no index, and whether it covers all versions.
+func (ndb *nodeDB) getKeyHistoryStart() (start int64, complete bool, err error) {
+	bz, err := ndb.db.Get(keyHistoryStartKeyFormat.Key())
+	if err != nil || bz == nil {
+		return 0, false, err
+	}
+	if len(bz) != int64Size+1 {
+		return 0, false, errors.Errorf("invalid key history start %X", bz)
+	}
+	return int64(binary.BigEndian.Uint64(bz)), bz[int64Size] == 1, nil
+}
+
+// getKeyHistoryValue looks up the value of a key at a version in the key history index. It
+// returns false if the version is not covered by the index.
+func (ndb *nodeDB) getKeyHistoryValue(key []byte, version int64) ([]byte, bool, error) {
+	start, complete, err := ndb.getKeyHistoryStart()
+	if err != nil || start == 0 || version < start {
+		return nil, false, err
+	}
+	from, value, ok, err := ndb.getKeyChange(key, version)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case ok && from >= start:
+		return value, true, nil
+	case complete && !ok:
+		return nil, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// getKeyChange returns the latest entry of a key in the key history index at or before a version.
+func (ndb *nodeDB) getKeyChange(key []byte, version int64) (from int64, value []byte, ok bool, err error) {
+	itr, err := ndb.db.ReverseIterator(keyHistoryPrefix(key), keyHistoryKey(key, version+1))
+	if err != nil {
+		return 0, nil, false, err
+	}
+	defer itr.Close()
+
+	if itr.Valid() {
+		k := itr.Key()
+		from = int64(binary.BigEndian.Uint64(k[len(k)-int64Size:]))
+		value, err = decodeKeyHistoryValue(itr.Value())
+		return from, value, err == nil, err
+	}
+	return 0, nil, false, itr.Error()
+}
+
+// getKeyChanges returns the changes of a key in the key history index from the given version,
+// at the first existing version where they are visible.
+func (ndb *nodeDB) getKeyChanges(key []byte, start int64, complete bool) ([]KeyChange, error) {
+	type entry struct {
+		version int64
+		value   []byte
+	}
+	var (
+		entries []entry
+		err     error
+	)
+	ndb.traversePrefix(keyHistoryPrefix(key), func(k, v []byte) {
+		if err != nil {
+			return
+		}
+		e := entry{version: int64(binary.BigEndian.Uint64(k[len(k)-int64Size:]))}
+		e.value, err = decodeKeyHistoryValue(v)
+		if e.version >= start {
+			entries = append(entries, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []KeyChange{}
+	for i, e := range entries {
+		// Deleted versions may leave entries that were never visible, or that repeat a value.
+		end := int64(math.MaxInt64)
+		if i+1 < len(entries) {
+			end = entries[i+1].version
+		}
+		version, err := ndb.firstVersionBetween(e.version, end)
+		if err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			continue
+		}
+		if len(changes) > 0 {
+			last := changes[len(changes)-1].Value
+			if (last == nil) == (e.value == nil) && bytes.Equal(last, e.value) {
+				continue
+			}
+		} else if complete && e.value == nil {
+			continue
+		}
+		changes = append(changes, KeyChange{Version: version, Value: e.value})
+	}
+	return changes, nil
+}
+
+// firstVersionBetween returns the first existing version in the interval [from, to), or 0.
+func (ndb *nodeDB) firstVersionBetween(from, to int64) (int64, error) {
+	itr, err := ndb.db.Iterator(rootKeyFormat.Key(from), rootKeyFormat.Key(to))
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	if itr.Valid() {
+		var version int64
+		rootKeyFormat.Scan(itr.Key(), &version)
+		return version, nil
+	}
+	return 0, itr.Error()
+}
+
+// deleteKeyChanges deletes the key history entries whose lifetime ends at a deleted version, if no
+// earlier version needs them, and otherwise moves their lifetime end to the predecessor version.
+// The caller must hold ndb.mtx.
+func (ndb *nodeDB) deleteKeyChanges(version, predecessor int64) {
+	ndb.traversePrefix(keyLifetimeKeyFormat.Key(version), func(k, v []byte) {
+		var to, from int64
+		keyLifetimeKeyFormat.Scan(k, &to, &from)
+		key := k[keyLifetimeKeyFormat.length:]
+
+		if err := ndb.batch.Delete(k); err != nil {
+			panic(err)
+		}
+		var err error
+		if from > predecessor {
+			err = ndb.batch.Delete(keyHistoryKey(key, from))
+		} else {
+			err = ndb.batch.Set(keyLifetimeKey(predecessor, from, key), []byte{})
+		}
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+// deleteKeyChangesFrom deletes the key history entries from the given version upwards, making the
+// entries they replaced current again. The index is discarded if it started after the version.
+func (ndb *nodeDB) deleteKeyChangesFrom(version int64) {
+	start, _, err := ndb.getKeyHistoryStart()
+	if err != nil {
+		panic(err)
+	}
+	if start >= version {
+		if err = ndb.batch.Delete(keyHistoryStartKeyFormat.Key()); err != nil {
+			panic(err)
+		}
+	}
+
+	// Entries replaced by a deleted entry have a lifetime ending at the latest remaining version
+	// or later, as do the deleted entries.
+	fn := func(k, v []byte) {
+		var to, from int64
+		keyLifetimeKeyFormat.Scan(k, &to, &from)
+		key := k[keyLifetimeKeyFormat.length:]
+
+		switch {
+		case from >= version:
+			if err := ndb.batch.Delete(k); err != nil {
+				panic(err)
+			}
+			if err := ndb.batch.Delete(keyHistoryKey(key, from)); err != nil {
+				panic(err)
+			}
+		case to != math.MaxInt64:
+			next, err := ndb.nextKeyChange(key, from)
+			if err != nil {
+				panic(err)
+			}
+			if next < version {
+				return
+			}
+			if err := ndb.batch.Delete(k); err != nil {
+				panic(err)
+			}
+			if err := ndb.batch.Set(keyLifetimeKey(math.MaxInt64, from, key), []byte{}); err != nil {
+				panic(err)
+			}
+		}
+	}
+	latest := ndb.getPreviousVersion(version)
+	ndb.traverseRange(keyLifetimeKeyFormat.Key(latest), keyLifetimeKeyFormat.Key(int64(math.MaxInt64)), fn)
+	ndb.traversePrefix(keyLifetimeKeyFormat.Key(int64(math.MaxInt64)), fn)
+}
+
+// nextKeyChange returns the version of the entry of a key following the one at the given version,
+// or math.MaxInt64 if there is none.
+func (ndb *nodeDB) nextKeyChange(key []byte, version int64) (int64, error) {
+	prefix := keyHistoryPrefix(key)
+	itr, err := ndb.db.Iterator(keyHistoryKey(key, version+1), keyHistoryKey(key, math.MaxInt64))
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	if itr.Valid() && bytes.HasPrefix(itr.Key(), prefix) {
+		k := itr.Key()
+		return int64(binary.BigEndian.Uint64(k[len(k)-int64Size:])), nil
+	}
+	return math.MaxInt64, itr.Error()
+}
+
+// keyHistoryPrefix returns the prefix of the key history entries of a key.
+func keyHistoryPrefix(key []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	prefix := append(keyHistoryKeyFormat.Key(), buf[:n]...)
+	return append(prefix, key...)
+}
+
+// keyHistoryKey returns the database key of the key history entry of a key at a version.
+func keyHistoryKey(key []byte, version int64) []byte {
+	var buf [int64Size]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(version))
+	return append(keyHistoryPrefix(key), buf[:]...)
+}
+
+// keyLifetimeKey returns the database key of the lifetime of a key history entry.
+func keyLifetimeKey(toVersion, fromVersion int64, key []byte) []byte {
+	return append(keyLifetimeKeyFormat.Key(toVersion, fromVersion), key...)
+}
+
+// keyHistoryValue encodes the value of a key history entry, with a nil value for removals.
+func keyHistoryValue(value []byte) []byte {
+	if value == nil {
+		return []byte{0}
+	}
+	return append([]byte{1}, value...)
+}
+
+func decodeKeyHistoryValue(bz []byte) ([]byte, error) {
+	switch {
+	case len(bz) == 1 && bz[0] == 0:
+		return nil, nil
+	case len(bz) >= 1 && bz[0] == 1:
+		return bz[1:], nil
+	default:
+		return nil, errors.Errorf("invalid key history entry %X", bz)
+	}
+}
@@ -0,0 +1,170 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// requireKeyHistory checks the key history index against the saved versions of the tree.
+func requireKeyHistory(t *testing.T, tree *MutableTree, keys [][]byte) {
+	versions := tree.AvailableVersions()
+	for _, key := range keys {
+		expect := []KeyChange{}
+		var last []byte
+		for _, v := range versions {
+			imm, err := tree.GetImmutable(int64(v))
+			require.NoError(t, err)
+			_, value := imm.Get(key)
+			actual, err := tree.GetVersionedValue(key, int64(v))
+			require.NoError(t, err)
+			require.Equal(t, value, actual, "key %s version %v", key, v)
+			if (value == nil) != (last == nil) || string(value) != string(last) {
+				expect = append(expect, KeyChange{Version: int64(v), Value: value})
+			}
+			last = value
+		}
+		history, err := tree.KeyHistory(key)
+		require.NoError(t, err)
+		require.Equal(t, expect, history, "key %s", key)
+	}
+}
+
+func TestKeyHistory(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(memDB, 0, &Options{KeyHistory: true})
+	require.NoError(t, err)
+	_, err = tree.KeyHistory([]byte("a"))
+	require.Error(t, err)
+
+	keys := [][]byte{}
+	for i := 0; i < 8; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("k%v", i)))
+	}
+	r := rand.New(rand.NewSource(1))
+	saveVersions := func(n int) {
+		for i := 0; i < n; i++ {
+			for j := 0; j < 3; j++ {
+				key := keys[r.Intn(len(keys))]
+				switch r.Intn(4) {
+				case 0:
+					tree.Remove(key)
+				case 1:
+					// Changes that are reverted within a version are not recorded.
+					tree.Set(key, []byte("tmp"))
+					tree.Remove(key)
+				default:
+					tree.Set(key, []byte(fmt.Sprintf("%v", r.Intn(3))))
+				}
+			}
+			_, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+		}
+	}
+	saveVersions(30)
+	requireKeyHistory(t, tree, keys)
+
+	// Deleting versions keeps the index correct, and deletes entries no longer needed.
+	require.NoError(t, tree.DeleteVersion(3))
+	require.NoError(t, tree.DeleteVersionsRange(10, 15))
+	require.NoError(t, tree.DeleteVersion(1))
+	requireKeyHistory(t, tree, keys)
+
+	for v := 2; v < 30; v++ {
+		if tree.VersionExists(int64(v)) {
+			require.NoError(t, tree.DeleteVersion(int64(v)))
+		}
+	}
+	requireKeyHistory(t, tree, keys)
+	for _, key := range keys {
+		count := 0
+		tree.ndb.traversePrefix(keyHistoryPrefix(key), func(k, v []byte) { count++ })
+		require.LessOrEqual(t, count, 1, "key %s", key)
+	}
+
+	// Overwriting versions restores the entries they replaced.
+	saveVersions(10)
+	_, err = tree.LoadVersionForOverwriting(34)
+	require.NoError(t, err)
+	requireKeyHistory(t, tree, keys)
+	saveVersions(5)
+	requireKeyHistory(t, tree, keys)
+
+	// The index is persisted.
+	tree, err = NewMutableTreeWithOpts(memDB, 0, &Options{KeyHistory: true})
+	require.NoError(t, err)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	requireKeyHistory(t, tree, keys)
+	for _, key := range keys {
+		_, ok, err := tree.ndb.getKeyHistoryValue(key, tree.Version())
+		require.NoError(t, err)
+		require.True(t, ok, "key %s was not found in the index", key)
+	}
+
+	_, err = tree.GetVersionedValue(keys[0], 1)
+	require.Equal(t, ErrVersionDoesNotExist, err)
+}
+
+func TestKeyHistory_Partial(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0)
+	require.NoError(t, err)
+	tree.Set([]byte("a"), []byte("1"))
+	tree.Set([]byte("b"), []byte("1"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	tree, err = NewMutableTreeWithOpts(memDB, 0, &Options{KeyHistory: true})
+	require.NoError(t, err)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	tree.Set([]byte("a"), []byte("2"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	tree.Set([]byte("c"), []byte("2"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Changes before the index was enabled are not included, but values are still found.
+	history, err := tree.KeyHistory([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []KeyChange{{Version: 2, Value: []byte("2")}}, history)
+	history, err = tree.KeyHistory([]byte("b"))
+	require.NoError(t, err)
+	require.Empty(t, history)
+	for _, v := range []int64{1, 2, 3} {
+		for _, key := range []string{"a", "b", "c"} {
+			imm, err := tree.GetImmutable(v)
+			require.NoError(t, err)
+			expectIndex, expect := imm.Get([]byte(key))
+			index, value := tree.GetVersioned([]byte(key), v)
+			require.Equal(t, expect, value, "key %v version %v", key, v)
+			require.Equal(t, expectIndex, index, "key %v version %v", key, v)
+			value, err = tree.GetVersionedValue([]byte(key), v)
+			require.NoError(t, err)
+			require.Equal(t, expect, value, "key %v version %v", key, v)
+		}
+	}
+
+	// Saving without the index discards it.
+	tree, err = NewMutableTree(memDB, 0)
+	require.NoError(t, err)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	tree.Set([]byte("a"), []byte("3"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.KeyHistory([]byte("a"))
+	require.Error(t, err)
+	index, value := tree.GetVersioned([]byte("a"), 2)
+	require.EqualValues(t, 0, index)
+	require.Equal(t, []byte("2"), value)
+	value, err = tree.GetVersionedValue([]byte("a"), 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), value)
+}
@@ -0,0 +1,159 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// MergeConflict is a key changed differently by both sides of a merge. Values are nil for keys
+// that are absent.
+type MergeConflict struct {
+	Key   []byte
+	Base  []byte
+	Left  []byte
+	Right []byte
+}
+
+// MergeResolver resolves a merge conflict, returning the merged value, or nil to remove the key.
+// It returns false to leave the conflict unresolved.
+type MergeResolver func(conflict MergeConflict) (value []byte, resolved bool)
+
+// Merge merges the changes of two branches relative to a common base tree, e.g. the version both
+// were forked from. The changes are found by walking the trees in key order, skipping subtrees
+// with the same hash. The result is a new branch forked from left, with the changes of right
+// applied in key order, so its hash is the one obtained by applying the changes of left, and then
+// those of right sorted by key. The input branches are not modified.
+//
+// Keys changed by both sides to the same value are merged. Keys changed differently are passed to
+// the resolver, if given, and unresolved conflicts keep their left value and are returned. The
+// caller must save or discard the resulting branch.
+func Merge(base *ImmutableTree, left, right *TreeBranch, resolve MergeResolver) (*TreeBranch, []MergeConflict, error) {
+	if left.release == nil || right.release == nil {
+		return nil, nil, errors.New("branch was already saved or discarded")
+	}
+	if left.tree.ndb != right.tree.ndb || base.ndb != left.tree.ndb {
+		return nil, nil, errors.New("trees belong to different databases")
+	}
+	leftChanges := diffKeys(base, left.tree.ImmutableTree)
+	rightChanges := diffKeys(base, right.tree.ImmutableTree)
+
+	merged := left.fork()
+	conflicts := []MergeConflict{}
+	i := 0
+	for _, change := range rightChanges {
+		for i < len(leftChanges) && bytes.Compare(leftChanges[i].key, change.key) < 0 {
+			i++
+		}
+		value := change.value
+		if i < len(leftChanges) && bytes.Equal(leftChanges[i].key, change.key) {
+			leftValue := leftChanges[i].value
+			if (leftValue == nil) == (value == nil) && bytes.Equal(leftValue, value) {
+				continue
+			}
+			conflict := MergeConflict{Key: change.key, Base: change.base, Left: leftValue, Right: value}
+			resolved := false
+			if resolve != nil {
+				value, resolved = resolve(conflict)
+			}
+			if !resolved {
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+		}
+		if value == nil {
+			merged.tree.Remove(change.key)
+		} else {
+			merged.tree.Set(change.key, value)
+		}
+	}
+	return merged, conflicts, nil
+}
+
+// fork returns a new branch with the same working tree and changes, based on the same version.
+func (b *TreeBranch) fork() *TreeBranch {
+	tree := b.tree
+	tree.ndb.incrVersionReaders(tree.version)
+	orphans := make(map[string]int64, len(tree.orphans))
+	for hash, version := range tree.orphans {
+		orphans[hash] = version
+	}
+	changedKeys := make(map[string]bool, len(tree.changedKeys))
+	for key := range tree.changedKeys {
+		changedKeys[key] = true
+	}
+	version := tree.version
+	return &TreeBranch{
+		tree: &MutableTree{
+			ImmutableTree: tree.ImmutableTree.clone(),
+			lastSaved:     tree.lastSaved,
+			orphans:       orphans,
+			changedKeys:   changedKeys,
+			versions:      map[int64]bool{},
+			ndb:           tree.ndb,
+		},
+		release: func() {
+			tree.ndb.decrVersionReaders(version)
+		},
+	}
+}
+
+// keyDiff is a key with a different value in two trees, with nil values for absent keys.
+type keyDiff struct {
+	key   []byte
+	base  []byte
+	value []byte
+}
+
+// diffKeys returns the keys with different values in two trees, in key order. Both trees are
+// traversed in order as stacks of pending subtrees, and subtrees at the top of both stacks with
+// the same hash are skipped. Trees that share most of their nodes, e.g. trees derived from the
+// same version, only descend into the changed subtrees.
+func diffKeys(base, tree *ImmutableTree) []keyDiff {
+	diffs := []keyDiff{}
+	var stackA, stackB []*Node
+	if base.root != nil {
+		base.Hash()
+		stackA = append(stackA, base.root)
+	}
+	if tree.root != nil {
+		tree.Hash()
+		stackB = append(stackB, tree.root)
+	}
+	// expand replaces the subtree at the top of a stack with its children.
+	expand := func(t *ImmutableTree, stack []*Node) []*Node {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return append(stack, node.getRightNode(t), node.getLeftNode(t))
+	}
+
+	for len(stackA) > 0 || len(stackB) > 0 {
+		var a, b *Node
+		if len(stackA) > 0 {
+			a = stackA[len(stackA)-1]
+		}
+		if len(stackB) > 0 {
+			b = stackB[len(stackB)-1]
+		}
+		switch {
+		case a != nil && b != nil && bytes.Equal(a.hash, b.hash):
+			stackA, stackB = stackA[:len(stackA)-1], stackB[:len(stackB)-1]
+		case a != nil && !a.isLeaf() && (b == nil || b.isLeaf() || a.height >= b.height):
+			stackA = expand(base, stackA)
+		case b != nil && !b.isLeaf():
+			stackB = expand(tree, stackB)
+		case b == nil || (a != nil && bytes.Compare(a.key, b.key) < 0):
+			diffs = append(diffs, keyDiff{key: a.key, base: a.value})
+			stackA = stackA[:len(stackA)-1]
+		case a == nil || bytes.Compare(b.key, a.key) < 0:
+			diffs = append(diffs, keyDiff{key: b.key, value: b.value})
+			stackB = stackB[:len(stackB)-1]
+		default:
+			if !bytes.Equal(a.value, b.value) {
+				diffs = append(diffs, keyDiff{key: a.key, base: a.value, value: b.value})
+			}
+			stackA, stackB = stackA[:len(stackA)-1], stackB[:len(stackB)-1]
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,133 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		tree.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("base"))
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	base, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	type op struct {
+		key, value []byte // remove if value is nil
+	}
+	r := rand.New(rand.NewSource(1))
+	// Each side changes its own keys, and the keys k000-k009 are changed by both.
+	randomOps := func(side string, offset int) []op {
+		ops := []op{}
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("k%03d", offset+r.Intn(100)))
+			if r.Intn(2) == 0 {
+				key = []byte(fmt.Sprintf("k%03d", r.Intn(10)))
+			}
+			var value []byte
+			if r.Intn(3) > 0 {
+				value = []byte(fmt.Sprintf("%v%d", side, r.Intn(2)))
+			}
+			ops = append(ops, op{key, value})
+		}
+		return ops
+	}
+	apply := func(set func(key, value []byte) bool, remove func(key []byte) ([]byte, bool), ops []op) {
+		for _, o := range ops {
+			if o.value != nil {
+				set(o.key, o.value)
+			} else {
+				remove(o.key)
+			}
+		}
+	}
+	leftOps, rightOps := randomOps("left", 10), randomOps("right", 100)
+
+	left, err := tree.Branch(1)
+	require.NoError(t, err)
+	apply(left.Set, left.Remove, leftOps)
+	right, err := tree.Branch(1)
+	require.NoError(t, err)
+	apply(right.Set, right.Remove, rightOps)
+	leftHash, rightHash := left.Hash(), right.Hash()
+
+	// The changes are found by comparing the trees.
+	for _, b := range []*TreeBranch{left, right} {
+		expect := []keyDiff{}
+		for i := 0; i < 300; i++ {
+			key := []byte(fmt.Sprintf("k%03d", i))
+			_, baseValue := base.Get(key)
+			value := b.Get(key)
+			if (baseValue == nil) != (value == nil) || !bytes.Equal(baseValue, value) {
+				expect = append(expect, keyDiff{key: key, base: baseValue, value: value})
+			}
+		}
+		require.Equal(t, expect, diffKeys(base, b.tree.ImmutableTree))
+	}
+
+	// Conflicts are resolved by the resolver, or returned.
+	resolved := 0
+	merged, conflicts, err := Merge(base, left, right, func(c MergeConflict) ([]byte, bool) {
+		require.NotEqual(t, c.Left, c.Right)
+		if bytes.Equal(c.Key, []byte("k000")) {
+			return nil, false
+		}
+		resolved++
+		return []byte("resolved"), true
+	})
+	require.NoError(t, err)
+	require.NotZero(t, resolved)
+	require.Len(t, conflicts, 1)
+	require.Equal(t, []byte("k000"), conflicts[0].Key)
+	require.Equal(t, leftHash, left.Hash())
+	require.Equal(t, rightHash, right.Hash())
+
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("k%03d", i))
+		_, baseValue := base.Get(key)
+		leftValue, rightValue := left.Get(key), right.Get(key)
+		differ := func(a, b []byte) bool { return (a == nil) != (b == nil) || !bytes.Equal(a, b) }
+		expect := leftValue
+		switch {
+		case differ(leftValue, baseValue) && differ(rightValue, baseValue) && differ(leftValue, rightValue):
+			if i > 0 {
+				expect = []byte("resolved")
+			}
+		case differ(rightValue, baseValue):
+			expect = rightValue
+		}
+		require.Equal(t, expect, merged.Get(key), "key %s", key)
+	}
+
+	// The merged hash is the one of applying the left changes, then the right changes in key order.
+	expect, err := tree.Branch(1)
+	require.NoError(t, err)
+	apply(expect.Set, expect.Remove, leftOps)
+	for _, c := range diffKeys(left.tree.ImmutableTree, merged.tree.ImmutableTree) {
+		if c.value == nil {
+			expect.Remove(c.key)
+		} else {
+			expect.Set(c.key, c.value)
+		}
+	}
+	require.Equal(t, expect.Hash(), merged.Hash())
+	expect.Discard()
+
+	// The merged branch can be saved.
+	mergedHash := merged.Hash()
+	left.Discard()
+	right.Discard()
+	hash, _, err := tree.SaveBranch(merged)
+	require.NoError(t, err)
+	require.Equal(t, mergedHash, hash)
+	_, _, err = Merge(base, left, merged, nil)
+	require.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"math"
 	"sort"
 
 	"github.com/pkg/errors"
@@ -26,6 +27,12 @@ type MutableTree struct {
 	*ImmutableTree                  // The current, working tree.
 	lastSaved      *ImmutableTree   // The most recently saved tree.
 	orphans        map[string]int64 // Nodes removed by changes to working tree.
+	changedKeys    map[string]bool  // Keys changed in the working tree, see Options.KeyHistory.
+	savepoints     []savepoint      // Savepoints of the working tree, innermost last.
+	nextSavepoint  int              // ID of the next savepoint.
+	orphansLog     []string         // Orphans added since the first savepoint, in order.
+	changedLog     []string         // Changed keys added since the first savepoint, in order.
+	batchNodes     map[*Node]bool   // Nodes created by the current SetBatch() call, changed in place.
 	versions       map[int64]bool   // The previous, saved versions of the tree.
 	allRootLoaded  bool             // Whether all roots are loaded or not(by LazyLoadVersion)
 	ndb            *nodeDB
@@ -45,6 +52,7 @@ func NewMutableTreeWithOpts(db dbm.DB, cacheSize int, opts *Options) (*MutableTr
 		ImmutableTree: head,
 		lastSaved:     head.clone(),
 		orphans:       map[string]int64{},
+		changedKeys:   map[string]bool{},
 		versions:      map[int64]bool{},
 		allRootLoaded: false,
 		ndb:           ndb,
@@ -114,9 +122,112 @@ func (tree *MutableTree) Set(key, value []byte) (updated bool) {
 	var orphaned []*Node
 	orphaned, updated = tree.set(key, value)
 	tree.addOrphans(orphaned)
+	if tree.ndb.opts.KeyHistory {
+		tree.addChangedKey(key)
+	}
 	return updated
 }
 
+// KVPair is a key/value pair.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// SetBatch sets several keys in the working tree, and returns the number of existing keys that
+// were updated. It results in the same tree as calling Set() for each pair in key order, with
+// pairs for the same key applied in the given order. The pairs are set in a single top-down pass:
+// at each inner node they are split between the two subtrees, and each subtree is descended once
+// for all its pairs, unless a rotation above it is needed in between. Nodes copied for the batch
+// are changed in place rather than copied and orphaned again. Nil values are invalid. The given
+// key/value byte slices must not be modified after this call.
+func (tree *MutableTree) SetBatch(pairs []KVPair) (updated int) {
+	sorted := make([]KVPair, len(pairs))
+	copy(sorted, pairs)
+	for _, pair := range sorted {
+		if pair.Value == nil {
+			panic(fmt.Sprintf("Attempt to store nil value at key '%s'", pair.Key))
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0
+	})
+	if len(sorted) == 0 {
+		return 0
+	}
+	if tree.ndb.opts.KeyHistory {
+		for _, pair := range sorted {
+			tree.addChangedKey(pair.Key)
+		}
+	}
+
+	tree.batchNodes = map[*Node]bool{}
+	defer func() { tree.batchNodes = nil }()
+	orphans := tree.prepareOrphansSlice()
+	if tree.root == nil {
+		tree.root = NewNode(sorted[0].Key, sorted[0].Value, tree.version+1)
+		sorted = sorted[1:]
+	}
+	if len(sorted) > 0 {
+		// The root has no height limit, so all pairs are set in one call.
+		tree.root, _, updated = tree.recursiveSetBatch(tree.root, sorted, math.MaxInt8, &orphans)
+	}
+	tree.addOrphans(orphans)
+	return updated
+}
+
+// recursiveSetBatch sets pairs sorted by key in the subtree of node, in order, with the same result
+// as calling recursiveSet() for each of them. Setting a pair can only raise the height of the
+// subtree by one, and a rotation above it is only needed once its height exceeds maxHeight, so
+// pairs are set until then, or until they run out. It returns the new subtree, the number of pairs
+// set (at least one), and how many of them updated an existing key.
+func (tree *MutableTree) recursiveSetBatch(node *Node, pairs []KVPair, maxHeight int8, orphans *[]*Node) (
+	newSelf *Node, n int, updated int,
+) {
+	version := tree.version + 1
+
+	for n < len(pairs) && node.height <= maxHeight {
+		if node.isLeaf() {
+			var isUpdate bool
+			node, isUpdate = tree.recursiveSet(node, pairs[n].Key, pairs[n].Value, orphans)
+			if isUpdate {
+				updated++
+			}
+			n++
+			continue
+		}
+
+		if !tree.batchNodes[node] {
+			*orphans = append(*orphans, node)
+			node = node.clone(version)
+			tree.batchNodes[node] = true
+		}
+
+		// The pairs of the left subtree come first. Each subtree may only grow until this node
+		// would need a rotation, or its own height would exceed maxHeight.
+		left, right := node.getLeftNode(tree.ImmutableTree), node.getRightNode(tree.ImmutableTree)
+		split := n + sort.Search(len(pairs)-n, func(i int) bool {
+			return bytes.Compare(pairs[n+i].Key, node.key) >= 0
+		})
+		var m, u int
+		if split > n {
+			limit := minInt8(right.height+1, maxHeight-1)
+			node.leftNode, m, u = tree.recursiveSetBatch(left, pairs[n:split], limit, orphans)
+			node.leftHash = nil // leftHash is yet unknown
+		} else {
+			limit := minInt8(left.height+1, maxHeight-1)
+			node.rightNode, m, u = tree.recursiveSetBatch(right, pairs[n:], limit, orphans)
+			node.rightHash = nil // rightHash is yet unknown
+		}
+		n += m
+		updated += u
+
+		node.calcHeightAndSize(tree.ImmutableTree)
+		node = tree.balance(node, orphans)
+	}
+	return node, n, updated
+}
+
 // Import returns an importer for tree nodes previously exported by ImmutableTree.Export(),
 // producing an identical IAVL tree. The caller must call Close() on the importer when done.
 //
@@ -199,6 +310,9 @@ func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orph
 func (tree *MutableTree) Remove(key []byte) ([]byte, bool) {
 	val, orphaned, removed := tree.remove(key)
 	tree.addOrphans(orphaned)
+	if removed && tree.ndb.opts.KeyHistory {
+		tree.addChangedKey(key)
+	}
 	return val, removed
 }
 
@@ -279,6 +393,92 @@ func (tree *MutableTree) recursiveRemove(node *Node, key []byte, orphans *[]*Nod
 	return newNode.hash, newNode, nil, value
 }
 
+// RemoveRange removes all keys in the range [start, end) from the working tree, and returns the
+// number of keys removed. A nil start or end leaves the range open on that side. Subtrees within
+// the range are cut out as a whole and the remaining parts are joined and rebalanced, so the
+// resulting tree has the same contents as removing each key with Remove, but may have a different
+// shape, and thus a different hash.
+func (tree *MutableTree) RemoveRange(start, end []byte) (removed int64) {
+	if tree.root == nil || (start != nil && end != nil && bytes.Compare(start, end) >= 0) {
+		return 0
+	}
+	orphans := tree.prepareOrphansSlice()
+	tree.root, removed = tree.recursiveRemoveRange(tree.root, start, end, nil, nil, &orphans)
+	tree.addOrphans(orphans)
+	return removed
+}
+
+// recursiveRemoveRange removes the keys in [start, end) from a subtree whose keys are known to be
+// in [lower, upper), with nil bounds if unknown. It returns the new subtree, or nil if it is empty.
+func (tree *MutableTree) recursiveRemoveRange(node *Node, start, end, lower, upper []byte, orphans *[]*Node) (
+	newSelf *Node, removed int64,
+) {
+	afterStart := start == nil || (lower != nil && bytes.Compare(start, lower) <= 0)
+	beforeEnd := end == nil || (upper != nil && bytes.Compare(upper, end) <= 0)
+	if node.isLeaf() {
+		afterStart = start == nil || bytes.Compare(start, node.key) <= 0
+		beforeEnd = end == nil || bytes.Compare(node.key, end) < 0
+	}
+	switch {
+	case afterStart && beforeEnd:
+		// The whole subtree is in the range, so all of its nodes are orphaned.
+		node.traverse(tree.ImmutableTree, true, func(n *Node) bool {
+			*orphans = append(*orphans, n)
+			if n.isLeaf() && tree.ndb.opts.KeyHistory {
+				tree.addChangedKey(n.key)
+			}
+			return false
+		})
+		return nil, node.size
+	case node.isLeaf(),
+		end != nil && lower != nil && bytes.Compare(lower, end) >= 0,
+		start != nil && upper != nil && bytes.Compare(upper, start) <= 0:
+		return node, 0
+	}
+
+	left, leftRemoved := tree.recursiveRemoveRange(node.getLeftNode(tree.ImmutableTree), start, end, lower, node.key, orphans)
+	right, rightRemoved := tree.recursiveRemoveRange(node.getRightNode(tree.ImmutableTree), start, end, node.key, upper, orphans)
+	if leftRemoved+rightRemoved == 0 {
+		return node, 0
+	}
+	*orphans = append(*orphans, node)
+	return tree.join(left, right, orphans), leftRemoved + rightRemoved
+}
+
+// join joins two subtrees, where all keys of left are smaller than the keys of right, into a
+// balanced subtree. Either may be nil.
+func (tree *MutableTree) join(left, right *Node, orphans *[]*Node) *Node {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.height > right.height+1:
+		*orphans = append(*orphans, left)
+		node := left.clone(tree.version + 1)
+		node.rightNode = tree.join(left.getRightNode(tree.ImmutableTree), right, orphans)
+		node.rightHash = nil
+		node.calcHeightAndSize(tree.ImmutableTree)
+		return tree.balance(node, orphans)
+	case right.height > left.height+1:
+		*orphans = append(*orphans, right)
+		node := right.clone(tree.version + 1)
+		node.leftNode = tree.join(left, right.getLeftNode(tree.ImmutableTree), orphans)
+		node.leftHash = nil
+		node.calcHeightAndSize(tree.ImmutableTree)
+		return tree.balance(node, orphans)
+	default:
+		node := &Node{
+			key:       right.lmd(tree.ImmutableTree).key,
+			leftNode:  left,
+			rightNode: right,
+			version:   tree.version + 1,
+		}
+		node.calcHeightAndSize(tree.ImmutableTree)
+		return node
+	}
+}
+
 // Load the latest versioned tree from disk.
 func (tree *MutableTree) Load() (int64, error) {
 	return tree.LoadVersion(int64(0))
@@ -292,6 +492,10 @@ func (tree *MutableTree) Load() (int64, error) {
 // performs a no-op. Otherwise, if the root does not exist, an error will be
 // returned.
 func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
+	if err := tree.deletePendingVersions(); err != nil {
+		return 0, err
+	}
+
 	latestVersion := tree.ndb.getLatestVersion()
 	if latestVersion < targetVersion {
 		return latestVersion, fmt.Errorf("wanted to load target %d but only found up to %d", targetVersion, latestVersion)
@@ -330,7 +534,7 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 		iTree.root = tree.ndb.GetNode(rootHash)
 	}
 
-	tree.orphans = map[string]int64{}
+	tree.resetChanges()
 	tree.ImmutableTree = iTree
 	tree.lastSaved = iTree.clone()
 
@@ -339,6 +543,10 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 
 // Returns the version number of the latest version found
 func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
+	if err := tree.deletePendingVersions(); err != nil {
+		return 0, err
+	}
+
 	roots, err := tree.ndb.getRoots()
 	if err != nil {
 		return 0, err
@@ -385,7 +593,7 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 		t.root = tree.ndb.GetNode(latestRoot)
 	}
 
-	tree.orphans = map[string]int64{}
+	tree.resetChanges()
 	tree.ImmutableTree = t
 	tree.lastSaved = t.clone()
 	tree.allRootLoaded = true
@@ -393,6 +601,32 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 	return latestVersion, nil
 }
 
+// deletePendingVersions carries out deferred version deletions, e.g. ones queued before a restart
+// or ones that failed when their last reader was released. Versions that are no longer queued are
+// then dropped from tree.versions.
+func (tree *MutableTree) deletePendingVersions() error {
+	if !tree.ndb.opts.DeferDeletions {
+		return nil
+	}
+	if err := tree.ndb.DeletePendingVersions(); err != nil {
+		return err
+	}
+	pending, err := tree.ndb.getPendingDeletions()
+	if err != nil {
+		return err
+	}
+	queued := make(map[int64]bool, len(pending))
+	for _, version := range pending {
+		queued[version] = true
+	}
+	for version, exists := range tree.versions {
+		if !exists && !queued[version] {
+			delete(tree.versions, version)
+		}
+	}
+	return nil
+}
+
 // LoadVersionForOverwriting attempts to load a tree at a previously committed
 // version, or the latest version below it. Any versions greater than targetVersion will be deleted.
 func (tree *MutableTree) LoadVersionForOverwriting(targetVersion int64) (int64, error) {
@@ -452,11 +686,13 @@ func (tree *MutableTree) Rollback() {
 	} else {
 		tree.ImmutableTree = &ImmutableTree{ndb: tree.ndb, version: 0}
 	}
-	tree.orphans = map[string]int64{}
+	tree.resetChanges()
 }
 
 // GetVersioned gets the value at the specified key and version. The returned value must not be
-// modified, since it may point to data stored within IAVL.
+// modified, since it may point to data stored within IAVL. The index of the key is found by
+// loading the tree at that version, so this doesn't use the key history index; see
+// GetVersionedValue() for a lookup without the index.
 func (tree *MutableTree) GetVersioned(key []byte, version int64) (
 	index int64, value []byte,
 ) {
@@ -473,6 +709,10 @@ func (tree *MutableTree) GetVersioned(key []byte, version int64) (
 // SaveVersion saves a new tree version to disk, based on the current state of
 // the tree. Returns the hash and new version number.
 func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
+	if err := tree.deletePendingVersions(); err != nil {
+		return nil, 0, err
+	}
+
 	version := tree.version + 1
 	if version == 1 && tree.ndb.opts.InitialVersion > 0 {
 		version = int64(tree.ndb.opts.InitialVersion)
@@ -498,13 +738,17 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 			tree.version = version
 			tree.ImmutableTree = tree.ImmutableTree.clone()
 			tree.lastSaved = tree.ImmutableTree.clone()
-			tree.orphans = map[string]int64{}
+			tree.resetChanges()
 			return existingHash, version, nil
 		}
 
 		return nil, version, fmt.Errorf("version %d was already saved to different hash %X (existing hash %X)", version, newHash, existingHash)
 	}
 
+	if err := tree.saveKeyHistory(version); err != nil {
+		return nil, version, err
+	}
+
 	if tree.root == nil {
 		// There can still be orphans, for example if the root is the node being
 		// removed.
@@ -532,7 +776,7 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 	// set new working tree
 	tree.ImmutableTree = tree.ImmutableTree.clone()
 	tree.lastSaved = tree.ImmutableTree.clone()
-	tree.orphans = map[string]int64{}
+	tree.resetChanges()
 
 	return tree.Hash(), version, nil
 }
@@ -594,7 +838,8 @@ func (tree *MutableTree) DeleteVersions(versions ...int64) error {
 }
 
 // DeleteVersionsRange removes versions from an interval from the MutableTree (not inclusive).
-// An error is returned if any single version has active readers.
+// An error is returned if any single version has active readers, unless Options.DeferDeletions
+// is set, in which case the deletion of those versions is queued like in DeleteVersion().
 // All writes happen in a single batch with a single commit.
 func (tree *MutableTree) DeleteVersionsRange(fromVersion, toVersion int64) error {
 	if err := tree.ndb.DeleteVersionsRange(fromVersion, toVersion); err != nil {
@@ -609,14 +854,32 @@ func (tree *MutableTree) DeleteVersionsRange(fromVersion, toVersion int64) error
 		delete(tree.versions, version)
 	}
 
+	if tree.ndb.opts.DeferDeletions {
+		pending, err := tree.ndb.getPendingDeletions()
+		if err != nil {
+			return err
+		}
+		for _, version := range pending {
+			if version >= fromVersion && version < toVersion {
+				tree.versions[version] = false
+			}
+		}
+	}
+
 	return nil
 }
 
 // DeleteVersion deletes a tree version from disk. The version can then no
-// longer be accessed.
+// longer be accessed. If the version has active readers, an error is returned
+// unless Options.DeferDeletions is set, in which case the deletion is queued
+// and carried out once the last reader is released.
 func (tree *MutableTree) DeleteVersion(version int64) error {
 	debug("DELETE VERSION: %d\n", version)
 
+	if err := tree.deletePendingVersions(); err != nil {
+		return err
+	}
+
 	if err := tree.deleteVersion(version); err != nil {
 		return err
 	}
@@ -625,10 +888,29 @@ func (tree *MutableTree) DeleteVersion(version int64) error {
 		return err
 	}
 
+	if tree.ndb.opts.DeferDeletions {
+		pending, err := tree.ndb.HasPendingDeletion(version)
+		if err != nil {
+			return err
+		}
+		if pending {
+			// The version is still on disk until its readers are released, so we can't rely on
+			// the root lookup in VersionExists().
+			tree.versions[version] = false
+			return nil
+		}
+	}
+
 	delete(tree.versions, version)
 	return nil
 }
 
+// PendingDeletions returns the versions whose deletion has been deferred until their active
+// readers are released, in ascending order. See Options.DeferDeletions.
+func (tree *MutableTree) PendingDeletions() ([]int64, error) {
+	return tree.ndb.getPendingDeletions()
+}
+
 // Rotate right and return the new node and orphan.
 func (tree *MutableTree) rotateRight(node *Node) (*Node, *Node) {
 	version := tree.version + 1
@@ -723,6 +1005,26 @@ func (tree *MutableTree) addOrphans(orphans []*Node) {
 		if len(node.hash) == 0 {
 			panic("Expected to find node hash, but was empty")
 		}
+		if _, ok := tree.orphans[string(node.hash)]; !ok && len(tree.savepoints) > 0 {
+			tree.orphansLog = append(tree.orphansLog, string(node.hash))
+		}
 		tree.orphans[string(node.hash)] = node.version
 	}
 }
+
+// addChangedKey records a key changed in the working tree, for the key history index.
+func (tree *MutableTree) addChangedKey(key []byte) {
+	if !tree.changedKeys[string(key)] && len(tree.savepoints) > 0 {
+		tree.changedLog = append(tree.changedLog, string(key))
+	}
+	tree.changedKeys[string(key)] = true
+}
+
+// resetChanges clears the changes tracked for the working tree, and its savepoints.
+func (tree *MutableTree) resetChanges() {
+	tree.orphans = map[string]int64{}
+	tree.changedKeys = map[string]bool{}
+	tree.savepoints = nil
+	tree.orphansLog = nil
+	tree.changedLog = nil
+}
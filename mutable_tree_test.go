@@ -3,10 +3,13 @@ package iavl
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"runtime"
+	"sort"
 	"strconv"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -380,3 +383,415 @@ func TestMutableTree_LazyLoadVersionWithEmptyTree(t *testing.T) {
 
 	require.True(t, newTree1.root == newTree2.root)
 }
+
+func TestMutableTree_DeleteVersion_ActiveReaders(t *testing.T) {
+	tree := prepareTree(t)
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	exporter := itree.Export()
+	defer exporter.Close()
+
+	require.Error(t, tree.DeleteVersion(1))
+	require.True(t, tree.VersionExists(1))
+
+	pending, err := tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestMutableTree_DeferDeletions(t *testing.T) {
+	mdb := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(mdb, 0, &Options{DeferDeletions: true})
+	require.NoError(t, err)
+	for v := byte(1); v <= 3; v++ {
+		tree.Set([]byte{v}, []byte{v})
+		tree.Set([]byte{0}, []byte{v})
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	exporter := itree.Export()
+
+	require.NoError(t, tree.DeleteVersion(1))
+	require.False(t, tree.VersionExists(1))
+	require.Equal(t, []int{2, 3}, tree.AvailableVersions())
+
+	pending, err := tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, pending)
+
+	// The version stays readable while the exporter is active.
+	hasRoot, err := tree.ndb.HasRoot(1)
+	require.NoError(t, err)
+	require.True(t, hasRoot)
+	exported := 0
+	for {
+		_, err := exporter.Next()
+		if err == ExportDone {
+			break
+		}
+		require.NoError(t, err)
+		exported++
+	}
+	require.Equal(t, 3, exported)
+
+	// Releasing the last reader carries out the deletion.
+	exporter.Close()
+	pending, err = tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	hasRoot, err = tree.ndb.HasRoot(1)
+	require.NoError(t, err)
+	require.False(t, hasRoot)
+
+	_, value := tree.GetVersioned([]byte{0}, 2)
+	require.Equal(t, []byte{2}, value)
+}
+
+func TestMutableTree_DeferDeletions_Restart(t *testing.T) {
+	mdb := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(mdb, 0, &Options{DeferDeletions: true})
+	require.NoError(t, err)
+	for v := byte(1); v <= 3; v++ {
+		tree.Set([]byte{0}, []byte{v})
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	itree, err := tree.GetImmutable(2)
+	require.NoError(t, err)
+	exporter := itree.Export()
+	defer exporter.Close()
+	require.NoError(t, tree.DeleteVersion(2))
+
+	// The queue is persisted, and processed when the tree is reloaded.
+	tree, err = NewMutableTreeWithOpts(mdb, 0, &Options{DeferDeletions: true})
+	require.NoError(t, err)
+	pending, err := tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Equal(t, []int64{2}, pending)
+
+	version, err := tree.Load()
+	require.NoError(t, err)
+	require.EqualValues(t, 3, version)
+	require.Equal(t, []int{1, 3}, tree.AvailableVersions())
+
+	pending, err = tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	require.Len(t, tree.ndb.orphans(), 1)
+}
+
+// failingWriteDB is a database whose batch writes fail while fail is set.
+type failingWriteDB struct {
+	db.DB
+	fail bool
+}
+
+func (d *failingWriteDB) NewBatch() db.Batch {
+	return &failingWriteBatch{Batch: d.DB.NewBatch(), db: d}
+}
+
+type failingWriteBatch struct {
+	db.Batch
+	db *failingWriteDB
+}
+
+func (b *failingWriteBatch) Write() error {
+	if b.db.fail {
+		return errors.New("write failed")
+	}
+	return b.Batch.Write()
+}
+
+func (b *failingWriteBatch) WriteSync() error {
+	if b.db.fail {
+		return errors.New("write failed")
+	}
+	return b.Batch.WriteSync()
+}
+
+func TestMutableTree_DeferDeletions_Retry(t *testing.T) {
+	mdb := &failingWriteDB{DB: db.NewMemDB()}
+	tree, err := NewMutableTreeWithOpts(mdb, 0, &Options{DeferDeletions: true})
+	require.NoError(t, err)
+	for v := byte(1); v <= 3; v++ {
+		tree.Set([]byte{0}, []byte{v})
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	exporter := itree.Export()
+	require.NoError(t, tree.DeleteVersion(1))
+
+	// A failed deletion leaves the version queued, rather than failing the reader.
+	mdb.fail = true
+	exporter.Close()
+	pending, err := tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Equal(t, []int64{1}, pending)
+	mdb.fail = false
+
+	// The next saved version retries it, and forgets about the version.
+	tree.Set([]byte{0}, []byte{4})
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	pending, err = tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	hasRoot, err := tree.ndb.HasRoot(1)
+	require.NoError(t, err)
+	require.False(t, hasRoot)
+	require.NotContains(t, tree.versions, int64(1))
+	require.Equal(t, []int{2, 3, 4}, tree.AvailableVersions())
+}
+
+func TestMutableTree_DeferDeletions_Range(t *testing.T) {
+	mdb := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(mdb, 0, &Options{DeferDeletions: true})
+	require.NoError(t, err)
+	for v := byte(1); v <= 5; v++ {
+		tree.Set([]byte{v}, []byte{v})
+		tree.Set([]byte{0}, []byte{v})
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	itree, err := tree.GetImmutable(3)
+	require.NoError(t, err)
+	exporter := itree.Export()
+
+	// The version with a reader is queued, and the others are deleted right away.
+	require.NoError(t, tree.DeleteVersionsRange(1, 5))
+	require.Equal(t, []int{5}, tree.AvailableVersions())
+	require.False(t, tree.VersionExists(3))
+	pending, err := tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Equal(t, []int64{3}, pending)
+	require.Equal(t, []int64{3, 5}, sortedVersions(tree.ndb.roots()))
+
+	exported := 0
+	for {
+		_, err := exporter.Next()
+		if err == ExportDone {
+			break
+		}
+		require.NoError(t, err)
+		exported++
+	}
+	require.Equal(t, 7, exported)
+
+	// Once released, only the nodes of the latest version remain.
+	exporter.Close()
+	pending, err = tree.PendingDeletions()
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	require.Equal(t, []int64{5}, sortedVersions(tree.ndb.roots()))
+	require.Empty(t, tree.ndb.orphans())
+	require.Len(t, tree.ndb.nodes(), 11)
+
+	// Without deferral, readers still fail the deletion.
+	tree, err = NewMutableTree(db.NewMemDB(), 0)
+	require.NoError(t, err)
+	for v := byte(1); v <= 3; v++ {
+		tree.Set([]byte{0}, []byte{v})
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	itree, err = tree.GetImmutable(2)
+	require.NoError(t, err)
+	exporter = itree.Export()
+	defer exporter.Close()
+	require.Error(t, tree.DeleteVersionsRange(1, 3))
+	require.Equal(t, []int{1, 2, 3}, tree.AvailableVersions())
+}
+
+func sortedVersions(roots map[int64][]byte) []int64 {
+	versions := make([]int64, 0, len(roots))
+	for version := range roots {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// requireBalanced checks the AVL invariants, sizes and inner node keys of a subtree, and returns
+// its leftmost key.
+func requireBalanced(t *testing.T, tree *ImmutableTree, node *Node) []byte {
+	if node.isLeaf() {
+		require.EqualValues(t, 1, node.size)
+		return node.key
+	}
+	left, right := node.getLeftNode(tree), node.getRightNode(tree)
+	leftmost := requireBalanced(t, tree, left)
+	require.Equal(t, requireBalanced(t, tree, right), node.key)
+	require.Equal(t, maxInt8(left.height, right.height)+1, node.height)
+	require.Equal(t, left.size+right.size, node.size)
+	require.LessOrEqual(t, absInt(node.calcBalance(tree)), 1)
+	return leftmost
+}
+
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func TestMutableTree_RemoveRange(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	mirror := map[string]string{}
+	set := func(key, value string) {
+		tree.Set([]byte(key), []byte(value))
+		mirror[key] = value
+	}
+	for i := 0; i < 1000; i++ {
+		set(fmt.Sprintf("k%04d", i), "v1")
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte {
+		if r.Intn(10) == 0 {
+			return nil
+		}
+		return []byte(fmt.Sprintf("k%04d", r.Intn(1100)))
+	}
+	for i := 0; i < 50; i++ {
+		// Mix changes of the working tree with removals.
+		for j := 0; j < 20; j++ {
+			set(fmt.Sprintf("k%04d", r.Intn(1100)), fmt.Sprintf("v%d", i))
+		}
+		start, end := randKey(), randKey()
+		if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+			start, end = end, start
+		}
+		expect := int64(0)
+		for key := range mirror {
+			if (start == nil || key >= string(start)) && (end == nil || key < string(end)) {
+				delete(mirror, key)
+				expect++
+			}
+		}
+		require.Equal(t, expect, tree.RemoveRange(start, end), "range %s-%s", start, end)
+		assertMirror(t, tree, mirror, 0)
+		if tree.root != nil {
+			requireBalanced(t, tree.ImmutableTree, tree.root)
+		}
+
+		if i%5 == 4 {
+			_, version, err := tree.SaveVersion()
+			require.NoError(t, err)
+			require.NoError(t, tree.DeleteVersion(version-1))
+		}
+	}
+	require.Zero(t, tree.RemoveRange([]byte("b"), []byte("a")))
+
+	// Orphans were recorded for all removed nodes, so only the latest nodes remain.
+	nodes := 0
+	tree.ndb.traverseNodes(func(hash []byte, node *Node) {
+		nodes++
+	})
+	reachable := 0
+	if tree.root != nil {
+		tree.root.traverse(tree.ImmutableTree, true, func(node *Node) bool {
+			reachable++
+			return false
+		})
+	}
+	require.Equal(t, reachable, nodes)
+
+	// Removing everything empties the tree.
+	tree.RemoveRange(nil, nil)
+	require.Nil(t, tree.root)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.NoError(t, tree.DeleteVersion(tree.Version()-1))
+	nodes = 0
+	tree.ndb.traverseNodes(func(hash []byte, node *Node) {
+		nodes++
+	})
+	require.Zero(t, nodes)
+}
+
+func TestMutableTree_SetBatch(t *testing.T) {
+	newTree := func() *MutableTree {
+		tree, err := getTestTree(0)
+		require.NoError(t, err)
+		for i := 0; i < 500; i++ {
+			tree.Set([]byte(fmt.Sprintf("k%04d", i*2)), []byte("v"))
+		}
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+		return tree
+	}
+	tree, expect := newTree(), newTree()
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		pairs := []KVPair{}
+		for j := 0; j < 200; j++ {
+			key := []byte(fmt.Sprintf("k%04d", r.Intn(1100)))
+			pairs = append(pairs, KVPair{Key: key, Value: []byte(fmt.Sprintf("v%d-%d", i, j))})
+		}
+		// Sequential sets in key order, with duplicates in their given order.
+		sorted := append([]KVPair{}, pairs...)
+		sort.SliceStable(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0 })
+		expectUpdated := 0
+		for _, pair := range sorted {
+			if expect.Set(pair.Key, pair.Value) {
+				expectUpdated++
+			}
+		}
+
+		require.Equal(t, expectUpdated, tree.SetBatch(pairs))
+		require.Equal(t, expect.WorkingHash(), tree.WorkingHash())
+		require.Equal(t, expect.orphans, tree.orphans)
+		require.Nil(t, tree.batchNodes)
+
+		hash, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		expectHash, _, err := expect.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, expectHash, hash)
+	}
+
+	// Batches into a new tree, which rotate at every level.
+	for _, n := range []int{1, 2, 3, 7, 64, 1000} {
+		tree, err := getTestTree(0)
+		require.NoError(t, err)
+		expect, err := getTestTree(0)
+		require.NoError(t, err)
+		pairs := []KVPair{}
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("k%04d", r.Intn(2*n)))
+			pairs = append(pairs, KVPair{Key: key, Value: []byte(fmt.Sprintf("v%d", i))})
+		}
+		sorted := append([]KVPair{}, pairs...)
+		sort.SliceStable(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0 })
+		expectUpdated := 0
+		for _, pair := range sorted {
+			if expect.Set(pair.Key, pair.Value) {
+				expectUpdated++
+			}
+		}
+		require.Equal(t, expectUpdated, tree.SetBatch(pairs), "batch of %v", n)
+		require.Equal(t, expect.WorkingHash(), tree.WorkingHash(), "batch of %v", n)
+		require.Equal(t, expect.Height(), tree.Height(), "batch of %v", n)
+	}
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	require.Zero(t, empty.SetBatch(nil))
+	require.Zero(t, empty.SetBatch([]KVPair{{Key: []byte("b"), Value: []byte("2")}, {Key: []byte("a"), Value: []byte("1")}}))
+	require.EqualValues(t, 2, empty.Size())
+	require.Panics(t, func() { empty.SetBatch([]KVPair{{Key: []byte("c")}}) })
+	require.EqualValues(t, 2, empty.Size())
+}
@@ -418,14 +418,22 @@ func (node *Node) getLeftNode(t *ImmutableTree) *Node {
 	if node.leftNode != nil {
 		return node.leftNode
 	}
-	return t.ndb.GetNode(node.leftHash)
+	leftNode := t.ndb.GetNode(node.leftHash)
+	if t.witness != nil {
+		t.witness.record(leftNode)
+	}
+	return leftNode
 }
 
 func (node *Node) getRightNode(t *ImmutableTree) *Node {
 	if node.rightNode != nil {
 		return node.rightNode
 	}
-	return t.ndb.GetNode(node.rightHash)
+	rightNode := t.ndb.GetNode(node.rightHash)
+	if t.witness != nil {
+		t.witness.record(rightNode)
+	}
+	return rightNode
 }
 
 // NOTE: mutates height and size
@@ -511,7 +519,22 @@ func (node *Node) traverseInRange(t *ImmutableTree, start, end []byte, ascending
 	return stop
 }
 
-// Only used in testing...
+// traverseChangedSince calls cb for the leaves written after the given version, in ascending order.
+// Every change creates new nodes up to the root, so subtrees with an earlier version are skipped.
+func (node *Node) traverseChangedSince(t *ImmutableTree, version int64, cb func(key, value []byte, version int64) bool) bool {
+	if node.version <= version {
+		return false
+	}
+	if node.isLeaf() {
+		return cb(node.key, node.value, node.version)
+	}
+	if node.getLeftNode(t).traverseChangedSince(t, version, cb) {
+		return true
+	}
+	return node.getRightNode(t).traverseChangedSince(t, version, cb)
+}
+
+// lmd returns the leftmost leaf of the subtree.
 func (node *Node) lmd(t *ImmutableTree) *Node {
 	if node.isLeaf() {
 		return node
@@ -33,6 +33,21 @@ var (
 
 	// Root nodes are indexed separately by their version
 	rootKeyFormat = NewKeyFormat('r', int64Size) // r<version>
+
+	// Versions whose deletion has been deferred until their active readers are released,
+	// see Options.DeferDeletions.
+	pendingDeletionKeyFormat = NewKeyFormat('p', int64Size) // p<version>
+
+	// Key history entries are indexed by the key and the version at which it changed, see
+	// Options.KeyHistory. Keys have variable length, so they are prefixed by it.
+	keyHistoryKeyFormat = NewKeyFormat('h') // h<uvarint-len><key><version>
+
+	// Key history entries are also indexed by their lifetime, like orphans, so that they can be
+	// deleted once no version needs them. The last version of current entries is math.MaxInt64.
+	keyLifetimeKeyFormat = NewKeyFormat('c', int64Size, int64Size) // c<last-version><first-version><key>
+
+	// The first version covered by the key history index, and whether it covers all versions.
+	keyHistoryStartKeyFormat = NewKeyFormat('H') // H
 )
 
 type nodeDB struct {
@@ -46,6 +61,10 @@ type nodeDB struct {
 	nodeCache      map[string]*list.Element // Node cache.
 	nodeCacheSize  int                      // Node cache size limit in elements.
 	nodeCacheQueue *list.List               // LRU queue of cache elements. Used for deletion.
+
+	stateless bool // nodes may be missing, since they were pruned from a witness
+
+	keyHistoryReset bool // the key history index has been discarded, see resetKeyHistory
 }
 
 func newNodeDB(db dbm.DB, cacheSize int, opts *Options) *nodeDB {
@@ -88,6 +107,9 @@ func (ndb *nodeDB) GetNode(hash []byte) *Node {
 		panic(fmt.Sprintf("can't get node %X: %v", hash, err))
 	}
 	if buf == nil {
+		if ndb.stateless {
+			panic(&MissingWitnessError{Hash: hash})
+		}
 		panic(fmt.Sprintf("Value missing for hash %x corresponding to nodeKey %x", hash, ndb.nodeKey(hash)))
 	}
 
@@ -200,14 +222,89 @@ func (ndb *nodeDB) DeleteVersion(version int64, checkLatestVersion bool) error {
 	defer ndb.mtx.Unlock()
 
 	if ndb.versionReaders[version] > 0 {
+		if ndb.opts.DeferDeletions {
+			debug("DEFER DELETE VERSION: %d\n", version)
+			return ndb.batch.Set(ndb.pendingDeletionKey(version), []byte{})
+		}
 		return errors.Errorf("unable to delete version %v, it has %v active readers", version, ndb.versionReaders[version])
 	}
 
 	ndb.deleteOrphans(version)
+	ndb.deleteKeyChanges(version, ndb.getPreviousVersion(version))
 	ndb.deleteRoot(version, checkLatestVersion)
 	return nil
 }
 
+// DeletePendingVersions carries out all deferred version deletions that no longer have active
+// readers. It writes directly to the database, and is called when loading the tree.
+func (ndb *nodeDB) DeletePendingVersions() error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+
+	versions, err := ndb.getPendingDeletions()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if ndb.versionReaders[version] > 0 {
+			continue
+		}
+		if err := ndb.deletePendingVersion(version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePendingVersion deletes a version queued for deletion, if any, along with its queue entry.
+// The deletion is written to the database in a separate batch, so that it does not interfere with
+// any pending writes of the working tree. The caller must hold ndb.mtx.
+func (ndb *nodeDB) deletePendingVersion(version int64) error {
+	pending, err := ndb.HasPendingDeletion(version)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return nil
+	}
+	hasRoot, err := ndb.HasRoot(version)
+	if err != nil {
+		return err
+	}
+	if hasRoot && version == ndb.getLatestVersion() {
+		// The version has become the latest one again, e.g. via LoadVersionForOverwriting(),
+		// and we never delete the latest version, so leave it queued.
+		return nil
+	}
+
+	debug("DELETE PENDING VERSION: %d\n", version)
+	batch := ndb.batch
+	ndb.batch = ndb.db.NewBatch()
+	defer func() {
+		ndb.batch.Close()
+		ndb.batch = batch
+	}()
+
+	// The root may already be gone, e.g. if it was removed by DeleteVersionsFrom().
+	if hasRoot {
+		ndb.deleteOrphans(version)
+		ndb.deleteKeyChanges(version, ndb.getPreviousVersion(version))
+		ndb.deleteRoot(version, false)
+	}
+	if err := ndb.batch.Delete(ndb.pendingDeletionKey(version)); err != nil {
+		return err
+	}
+	if ndb.opts.Sync {
+		err = ndb.batch.WriteSync()
+	} else {
+		err = ndb.batch.Write()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write batch")
+	}
+	return nil
+}
+
 // DeleteVersionsFrom permanently deletes all tree versions from the given version upwards.
 func (ndb *nodeDB) DeleteVersionsFrom(version int64) error {
 	latest := ndb.getLatestVersion()
@@ -257,6 +354,8 @@ func (ndb *nodeDB) DeleteVersionsFrom(version int64) error {
 		}
 	})
 
+	ndb.deleteKeyChangesFrom(version)
+
 	// Finally, delete the version root entries
 	ndb.traverseRange(rootKeyFormat.Key(version), rootKeyFormat.Key(int64(math.MaxInt64)), func(k, v []byte) {
 		if err := ndb.batch.Delete(k); err != nil {
@@ -267,7 +366,9 @@ func (ndb *nodeDB) DeleteVersionsFrom(version int64) error {
 	return nil
 }
 
-// DeleteVersionsRange deletes versions from an interval (not inclusive).
+// DeleteVersionsRange deletes versions from an interval (not inclusive). Versions with active
+// readers are queued for deletion if Options.DeferDeletions is set, and the versions between them
+// are deleted as separate ranges. Otherwise, an error is returned.
 func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 	if fromVersion >= toVersion {
 		return errors.New("toVersion must be greater than fromVersion")
@@ -286,11 +387,39 @@ func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 
 	predecessor := ndb.getPreviousVersion(fromVersion)
 
+	deferred := []int64{}
 	for v, r := range ndb.versionReaders {
 		if v < toVersion && v > predecessor && r != 0 {
-			return errors.Errorf("unable to delete version %v with %v active readers", v, r)
+			if !ndb.opts.DeferDeletions {
+				return errors.Errorf("unable to delete version %v with %v active readers", v, r)
+			}
+			if v >= fromVersion {
+				deferred = append(deferred, v)
+			}
+		}
+	}
+	sort.Slice(deferred, func(i, j int) bool { return deferred[i] < deferred[j] })
+
+	for _, version := range deferred {
+		debug("DEFER DELETE VERSION: %d\n", version)
+		if err := ndb.batch.Set(ndb.pendingDeletionKey(version), []byte{}); err != nil {
+			return err
+		}
+		if fromVersion < version {
+			ndb.deleteVersionsRange(fromVersion, version)
 		}
+		fromVersion = version + 1
 	}
+	if fromVersion < toVersion {
+		ndb.deleteVersionsRange(fromVersion, toVersion)
+	}
+	return nil
+}
+
+// deleteVersionsRange deletes the versions from an interval (not inclusive), none of which may
+// have active readers. The caller must hold ndb.mtx.
+func (ndb *nodeDB) deleteVersionsRange(fromVersion, toVersion int64) {
+	predecessor := ndb.getPreviousVersion(fromVersion)
 
 	// If the predecessor is earlier than the beginning of the lifetime, we can delete the orphan.
 	// Otherwise, we shorten its lifetime, by moving its endpoint to the predecessor version.
@@ -310,6 +439,7 @@ func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 				ndb.saveOrphan(hash, from, predecessor)
 			}
 		})
+		ndb.deleteKeyChanges(version, predecessor)
 	}
 
 	// Delete the version root entries
@@ -318,8 +448,6 @@ func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 			panic(err)
 		}
 	})
-
-	return nil
 }
 
 // deleteNodesFrom deletes the given node and any descendants that have versions after the given
@@ -427,6 +555,10 @@ func (ndb *nodeDB) rootKey(version int64) []byte {
 	return rootKeyFormat.Key(version)
 }
 
+func (ndb *nodeDB) pendingDeletionKey(version int64) []byte {
+	return pendingDeletionKeyFormat.Key(version)
+}
+
 func (ndb *nodeDB) getLatestVersion() int64 {
 	if ndb.latestVersion == 0 {
 		ndb.latestVersion = ndb.getPreviousVersion(1<<63 - 1)
@@ -567,6 +699,10 @@ func (ndb *nodeDB) HasRoot(version int64) (bool, error) {
 	return ndb.db.Has(ndb.rootKey(version))
 }
 
+func (ndb *nodeDB) HasPendingDeletion(version int64) (bool, error) {
+	return ndb.db.Has(ndb.pendingDeletionKey(version))
+}
+
 func (ndb *nodeDB) getRoot(version int64) ([]byte, error) {
 	return ndb.db.Get(ndb.rootKey(version))
 }
@@ -582,6 +718,18 @@ func (ndb *nodeDB) getRoots() (map[int64][]byte, error) {
 	return roots, nil
 }
 
+// getPendingDeletions returns the versions queued for deletion, in ascending order.
+func (ndb *nodeDB) getPendingDeletions() ([]int64, error) {
+	versions := []int64{}
+
+	ndb.traversePrefix(pendingDeletionKeyFormat.Key(), func(k, v []byte) {
+		var version int64
+		pendingDeletionKeyFormat.Scan(k, &version)
+		versions = append(versions, version)
+	})
+	return versions, nil
+}
+
 // SaveRoot creates an entry on disk for the given root, so that it can be
 // loaded later.
 func (ndb *nodeDB) SaveRoot(root *Node, version int64) error {
@@ -627,6 +775,13 @@ func (ndb *nodeDB) decrVersionReaders(version int64) {
 	if ndb.versionReaders[version] > 0 {
 		ndb.versionReaders[version]--
 	}
+	if ndb.versionReaders[version] == 0 && ndb.opts.DeferDeletions {
+		// Readers have no use for deletion errors, so a failed deletion is left queued, and is
+		// retried when the tree next saves or deletes a version, or is loaded.
+		if err := ndb.deletePendingVersion(version); err != nil {
+			debug("DELETE PENDING VERSION %d FAILED: %v\n", version, err)
+		}
+	}
 }
 
 // Utility and test functions
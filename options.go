@@ -10,6 +10,20 @@ type Options struct {
 	// this, an error is returned when loading the tree. Only used for the initial SaveVersion()
 	// call.
 	InitialVersion uint64
+
+	// DeferDeletions queues the deletion of versions that have active readers (e.g. an Exporter)
+	// by DeleteVersion() and DeleteVersionsRange(), instead of returning an error. Queued deletions
+	// are persisted, and are carried out when the last reader of the version is released or the
+	// tree is next loaded. A deletion that fails when the reader is released stays queued, and is
+	// retried by the next SaveVersion() or DeleteVersion().
+	DeferDeletions bool
+
+	// KeyHistory maintains an index of the versions at which each key changed, along with its
+	// values, which is written by SaveVersion(). It is used by KeyHistory() and GetVersionedValue().
+	// The index stores a copy of every changed value, and only covers changes saved while the
+	// option is enabled: disabling it discards the index, and enabling it on a tree with existing
+	// versions starts a partial index, for which older queries fall back to loading the version.
+	KeyHistory bool
 }
 
 // DefaultOptions returns the default options for IAVL.
@@ -0,0 +1,129 @@
+package iavl
+
+import (
+	ics23 "github.com/confio/ics23/go"
+)
+
+// PrefixedTree is a view of the keys of a MutableTree that start with a prefix, e.g. to store
+// several logical tables in a single tree. Keys are given and returned without the prefix. Reads
+// use the working tree of the underlying tree, and proofs are for the full keys, so that they
+// verify against the root hash of the underlying tree.
+type PrefixedTree struct {
+	tree   *MutableTree
+	prefix []byte
+	end    []byte // the first key after the prefixed keys, or nil if there is none
+}
+
+// Prefixed returns a view of the keys of the tree with the given prefix.
+func Prefixed(tree *MutableTree, prefix []byte) *PrefixedTree {
+	prefix = cp(prefix)
+	return &PrefixedTree{tree: tree, prefix: prefix, end: prefixEnd(prefix)}
+}
+
+// prefixEnd returns the first key after all keys with the given prefix, or nil if there is none,
+// i.e. if the prefix is empty or only has 0xFF bytes.
+func prefixEnd(prefix []byte) []byte {
+	end := cp(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Prefix returns the prefix of the view.
+func (p *PrefixedTree) Prefix() []byte {
+	return p.prefix
+}
+
+// FullKey returns the key in the underlying tree for a key of the view, as used by proofs.
+func (p *PrefixedTree) FullKey(key []byte) []byte {
+	full := make([]byte, 0, len(p.prefix)+len(key))
+	return append(append(full, p.prefix...), key...)
+}
+
+// Size returns the number of keys in the view.
+func (p *PrefixedTree) Size() int64 {
+	return p.tree.CountRange(p.prefix, p.end)
+}
+
+// Get returns the index within the view and value of the specified key if it exists, or nil and
+// the next index otherwise. The returned value must not be modified.
+func (p *PrefixedTree) Get(key []byte) (index int64, value []byte) {
+	index, value = p.tree.Get(p.FullKey(key))
+	return index - p.tree.Rank(p.prefix), value
+}
+
+// Has returns whether or not a key exists.
+func (p *PrefixedTree) Has(key []byte) bool {
+	return p.tree.Has(p.FullKey(key))
+}
+
+// Set sets a key in the working tree, like MutableTree.Set(). It returns true when an existing
+// value was updated, while false means it was a new key.
+func (p *PrefixedTree) Set(key, value []byte) (updated bool) {
+	return p.tree.Set(p.FullKey(key), value)
+}
+
+// Remove removes a key from the working tree, and returns its value if it was removed.
+func (p *PrefixedTree) Remove(key []byte) ([]byte, bool) {
+	return p.tree.Remove(p.FullKey(key))
+}
+
+// Iterate iterates over all keys of the view, in order. The keys and values must not be
+// modified.
+func (p *PrefixedTree) Iterate(fn func(key []byte, value []byte) bool) (stopped bool) {
+	return p.IterateRange(nil, nil, true, fn)
+}
+
+// IterateRange iterates over the keys of the view between start and end non-inclusive. If either
+// is nil, the range is open on that side. The keys and values must not be modified.
+func (p *PrefixedTree) IterateRange(start, end []byte, ascending bool, fn func(key []byte, value []byte) bool) (stopped bool) {
+	start, end = p.bounds(start, end)
+	return p.tree.IterateRange(start, end, ascending, func(key, value []byte) bool {
+		return fn(key[len(p.prefix):], value)
+	})
+}
+
+// GetWithProof gets the value of a key along with a proof of its existence or absence in the
+// underlying tree, for the full key.
+func (p *PrefixedTree) GetWithProof(key []byte) ([]byte, *RangeProof, error) {
+	return p.tree.GetWithProof(p.FullKey(key))
+}
+
+// GetRangeWithProof gets the keys and values of the view in the range [start, end), where a nil
+// bound is open, along with a proof for the full keys. The limit applies to the leaves of the
+// proof, as for ImmutableTree.GetRangeWithProof(), and 0 is unlimited.
+func (p *PrefixedTree) GetRangeWithProof(start, end []byte, limit int) (keys, values [][]byte, proof *RangeProof, err error) {
+	start, end = p.bounds(start, end)
+	keys, values, proof, err = p.tree.GetRangeWithProof(start, end, limit)
+	for i, key := range keys {
+		keys[i] = key[len(p.prefix):]
+	}
+	return keys, values, proof, err
+}
+
+// GetMembershipProof returns an ICS23 proof that the full key exists in the underlying tree.
+func (p *PrefixedTree) GetMembershipProof(key []byte) (*ics23.CommitmentProof, error) {
+	return p.tree.GetMembershipProof(p.FullKey(key))
+}
+
+// GetNonMembershipProof returns an ICS23 proof that the full key does not exist in the underlying
+// tree.
+func (p *PrefixedTree) GetNonMembershipProof(key []byte) (*ics23.CommitmentProof, error) {
+	return p.tree.GetNonMembershipProof(p.FullKey(key))
+}
+
+// bounds returns the range of full keys for a range of the view.
+func (p *PrefixedTree) bounds(start, end []byte) ([]byte, []byte) {
+	fullStart, fullEnd := p.prefix, p.end
+	if start != nil {
+		fullStart = p.FullKey(start)
+	}
+	if end != nil {
+		fullEnd = p.FullKey(end)
+	}
+	return fullStart, fullEnd
+}
@@ -0,0 +1,88 @@
+package iavl
+
+import (
+	"testing"
+
+	ics23 "github.com/confio/ics23/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	require.Nil(t, prefixEnd(nil))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	require.Equal(t, []byte{0x01}, prefixEnd([]byte{0x00}))
+	require.Equal(t, []byte{0x01, 0x03}, prefixEnd([]byte{0x01, 0x02}))
+	require.Equal(t, []byte{0x02}, prefixEnd([]byte{0x01, 0xff, 0xff}))
+}
+
+func TestPrefixedTree(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "b/", "c", "\xff"} {
+		tree.Set([]byte(key), []byte("other"))
+	}
+
+	users := Prefixed(tree, []byte("b/"))
+	require.False(t, users.Set([]byte("bob"), []byte("1")))
+	require.False(t, users.Set([]byte("alice"), []byte("2")))
+	require.False(t, users.Set([]byte("carol"), []byte("3")))
+	require.True(t, users.Set([]byte("carol"), []byte("4")))
+	require.True(t, tree.Has([]byte("b/bob")))
+	require.EqualValues(t, 4, users.Size())
+
+	index, value := users.Get([]byte("bob"))
+	require.EqualValues(t, 2, index)
+	require.Equal(t, []byte("1"), value)
+	index, value = users.Get([]byte("dave"))
+	require.EqualValues(t, 4, index)
+	require.Nil(t, value)
+	// The key equal to the prefix is the empty key of the view.
+	require.True(t, users.Has([]byte("")))
+
+	value, removed := users.Remove([]byte("alice"))
+	require.True(t, removed)
+	require.Equal(t, []byte("2"), value)
+	require.False(t, tree.Has([]byte("b/alice")))
+
+	// collect iterates over a range of a view.
+	collect := func(view *PrefixedTree, start, end []byte, ascending bool) []string {
+		keys := []string{}
+		view.IterateRange(start, end, ascending, func(key, value []byte) bool {
+			keys = append(keys, string(key))
+			return false
+		})
+		return keys
+	}
+	require.Equal(t, []string{"", "bob", "carol"}, collect(users, nil, nil, true))
+	require.Equal(t, []string{"carol", "bob", ""}, collect(users, nil, nil, false))
+	require.Equal(t, []string{"carol"}, collect(users, []byte("c"), nil, true))
+	require.Equal(t, []string{"", "bob"}, collect(users, nil, []byte("c"), true))
+	require.Equal(t, []string{"", "/", "/bob", "/carol"}, collect(Prefixed(tree, []byte("b")), nil, nil, true))
+	require.Equal(t, []string{""}, collect(Prefixed(tree, []byte{0xff}), nil, nil, true))
+	require.Equal(t, []string{"a", "b", "b/", "b/bob", "b/carol", "c", "\xff"}, collect(Prefixed(tree, nil), nil, nil, true))
+
+	// Proofs are for the full keys, and verify against the root of the tree.
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+	require.Equal(t, []byte("b/carol"), users.FullKey([]byte("carol")))
+
+	value, proof, err := users.GetWithProof([]byte("carol"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("4"), value)
+	require.NoError(t, proof.Verify(root))
+	require.NoError(t, proof.VerifyItem(users.FullKey([]byte("carol")), value))
+
+	keys, values, proof, err := users.GetRangeWithProof(nil, nil, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{}, []byte("bob"), []byte("carol")}, keys)
+	require.Equal(t, [][]byte{[]byte("other"), []byte("1"), []byte("4")}, values)
+	require.NoError(t, proof.Verify(root))
+
+	commitment, err := users.GetMembershipProof([]byte("bob"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyMembership(ics23.IavlSpec, root, commitment, users.FullKey([]byte("bob")), []byte("1")))
+	commitment, err = users.GetNonMembershipProof([]byte("alice"))
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, commitment, users.FullKey([]byte("alice"))))
+}
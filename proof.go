@@ -2,196 +2,35 @@ package iavl
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"fmt"
-	"math"
 
 	"github.com/pkg/errors"
 
-	cmn "github.com/cosmos/iavl/common"
-	iavlproto "github.com/cosmos/iavl/proto"
+	iavlproof "github.com/cosmos/iavl/proof"
 )
 
+// The proof types and their verification live in the proof package, which doesn't depend on the
+// tree storage. They are aliased here for backwards compatibility.
+
 var (
 	// ErrInvalidProof is returned by Verify when a proof cannot be validated.
-	ErrInvalidProof = fmt.Errorf("invalid proof")
+	ErrInvalidProof = iavlproof.ErrInvalidProof
 
 	// ErrInvalidInputs is returned when the inputs passed to the function are invalid.
-	ErrInvalidInputs = fmt.Errorf("invalid inputs")
+	ErrInvalidInputs = iavlproof.ErrInvalidInputs
 
 	// ErrInvalidRoot is returned when the root passed in does not match the proof's.
-	ErrInvalidRoot = fmt.Errorf("invalid root")
+	ErrInvalidRoot = iavlproof.ErrInvalidRoot
 )
 
-//----------------------------------------
-
-type ProofInnerNode struct {
-	Height  int8   `json:"height"`
-	Size    int64  `json:"size"`
-	Version int64  `json:"version"`
-	Left    []byte `json:"left"`
-	Right   []byte `json:"right"`
-}
-
-func (pin ProofInnerNode) String() string {
-	return pin.stringIndented("")
-}
-
-func (pin ProofInnerNode) stringIndented(indent string) string {
-	return fmt.Sprintf(`ProofInnerNode{
-%s  Height:  %v
-%s  Size:    %v
-%s  Version: %v
-%s  Left:    %X
-%s  Right:   %X
-%s}`,
-		indent, pin.Height,
-		indent, pin.Size,
-		indent, pin.Version,
-		indent, pin.Left,
-		indent, pin.Right,
-		indent)
-}
-
-func (pin ProofInnerNode) Hash(childHash []byte) []byte {
-	hasher := sha256.New()
-	buf := new(bytes.Buffer)
-
-	err := encodeVarint(buf, int64(pin.Height))
-	if err == nil {
-		err = encodeVarint(buf, pin.Size)
-	}
-	if err == nil {
-		err = encodeVarint(buf, pin.Version)
-	}
-
-	if len(pin.Left) == 0 {
-		if err == nil {
-			err = encodeBytes(buf, childHash)
-		}
-		if err == nil {
-			err = encodeBytes(buf, pin.Right)
-		}
-	} else {
-		if err == nil {
-			err = encodeBytes(buf, pin.Left)
-		}
-		if err == nil {
-			err = encodeBytes(buf, childHash)
-		}
-	}
-	if err != nil {
-		panic(fmt.Sprintf("Failed to hash ProofInnerNode: %v", err))
-	}
-
-	_, err = hasher.Write(buf.Bytes())
-	if err != nil {
-		panic(err)
-	}
-	return hasher.Sum(nil)
-}
-
-// toProto converts the inner node proof to Protobuf, for use in ProofOps.
-func (pin ProofInnerNode) toProto() *iavlproto.ProofInnerNode {
-	return &iavlproto.ProofInnerNode{
-		Height:  int32(pin.Height),
-		Size_:   pin.Size,
-		Version: pin.Version,
-		Left:    pin.Left,
-		Right:   pin.Right,
-	}
-}
-
-// proofInnerNodeFromProto converts a Protobuf ProofInnerNode to a ProofInnerNode.
-func proofInnerNodeFromProto(pbInner *iavlproto.ProofInnerNode) (ProofInnerNode, error) {
-	if pbInner == nil {
-		return ProofInnerNode{}, errors.New("inner node cannot be nil")
-	}
-	if pbInner.Height > math.MaxInt8 || pbInner.Height < math.MinInt8 {
-		return ProofInnerNode{}, fmt.Errorf("height must fit inside an int8, got %v", pbInner.Height)
-	}
-	return ProofInnerNode{
-		Height:  int8(pbInner.Height),
-		Size:    pbInner.Size_,
-		Version: pbInner.Version,
-		Left:    pbInner.Left,
-		Right:   pbInner.Right,
-	}, nil
-}
-
-//----------------------------------------
-
-type ProofLeafNode struct {
-	Key       cmn.HexBytes `json:"key"`
-	ValueHash cmn.HexBytes `json:"value"`
-	Version   int64        `json:"version"`
-}
-
-func (pln ProofLeafNode) String() string {
-	return pln.stringIndented("")
-}
-
-func (pln ProofLeafNode) stringIndented(indent string) string {
-	return fmt.Sprintf(`ProofLeafNode{
-%s  Key:       %v
-%s  ValueHash: %X
-%s  Version:   %v
-%s}`,
-		indent, pln.Key,
-		indent, pln.ValueHash,
-		indent, pln.Version,
-		indent)
-}
-
-func (pln ProofLeafNode) Hash() []byte {
-	hasher := sha256.New()
-	buf := new(bytes.Buffer)
-
-	err := encodeVarint(buf, 0)
-	if err == nil {
-		err = encodeVarint(buf, 1)
-	}
-	if err == nil {
-		err = encodeVarint(buf, pln.Version)
-	}
-	if err == nil {
-		err = encodeBytes(buf, pln.Key)
-	}
-	if err == nil {
-		err = encodeBytes(buf, pln.ValueHash)
-	}
-	if err != nil {
-		panic(fmt.Sprintf("Failed to hash ProofLeafNode: %v", err))
-	}
-	_, err = hasher.Write(buf.Bytes())
-	if err != nil {
-		panic(err)
-
-	}
-
-	return hasher.Sum(nil)
-}
-
-// toProto converts the leaf node proof to Protobuf, for use in ProofOps.
-func (pln ProofLeafNode) toProto() *iavlproto.ProofLeafNode {
-	return &iavlproto.ProofLeafNode{
-		Key:       pln.Key,
-		ValueHash: pln.ValueHash,
-		Version:   pln.Version,
-	}
-}
-
-// proofLeafNodeFromProto converts a Protobuf ProofLeadNode to a ProofLeafNode.
-func proofLeafNodeFromProto(pbLeaf *iavlproto.ProofLeafNode) (ProofLeafNode, error) {
-	if pbLeaf == nil {
-		return ProofLeafNode{}, errors.New("leaf node cannot be nil")
-	}
-	return ProofLeafNode{
-		Key:       pbLeaf.Key,
-		ValueHash: pbLeaf.ValueHash,
-		Version:   pbLeaf.Version,
-	}, nil
-}
+type (
+	ProofInnerNode  = iavlproof.ProofInnerNode
+	ProofLeafNode   = iavlproof.ProofLeafNode
+	PathToLeaf      = iavlproof.PathToLeaf
+	RangeProof      = iavlproof.RangeProof
+	RangeCountProof = iavlproof.RangeCountProof
+	RangePage       = iavlproof.RangePage
+	PageToken       = iavlproof.PageToken
+)
 
 //----------------------------------------
 
@@ -1,4 +1,4 @@
-package iavl
+package proof
 
 import (
 	"fmt"
@@ -8,7 +8,8 @@ import (
 	"github.com/tendermint/tendermint/crypto/merkle"
 	tmmerkle "github.com/tendermint/tendermint/proto/tendermint/crypto"
 
-	iavlproto "github.com/cosmos/iavl/proto"
+	"github.com/cosmos/iavl/internal/encoding"
+	"github.com/cosmos/iavl/proto/proofpb"
 )
 
 const ProofOpIAVLAbsence = "iavl:a"
@@ -41,14 +42,14 @@ func AbsenceOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
 		return nil, errors.Errorf("unexpected ProofOp.Type; got %v, want %v", pop.Type, ProofOpIAVLAbsence)
 	}
 	// Strip the varint length prefix, used for backwards compatibility with Amino.
-	bz, n, err := decodeBytes(pop.Data)
+	bz, n, err := encoding.DecodeBytes(pop.Data)
 	if err != nil {
 		return nil, err
 	}
 	if n != len(pop.Data) {
 		return nil, fmt.Errorf("unexpected bytes, expected %v got %v", n, len(pop.Data))
 	}
-	pbProofOp := &iavlproto.AbsenceOp{}
+	pbProofOp := &proofpb.AbsenceOp{}
 	err = proto.Unmarshal(bz, pbProofOp)
 	if err != nil {
 		return nil, err
@@ -61,13 +62,13 @@ func AbsenceOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
 }
 
 func (op AbsenceOp) ProofOp() tmmerkle.ProofOp {
-	pbProof := iavlproto.AbsenceOp{Proof: op.Proof.ToProto()}
+	pbProof := proofpb.AbsenceOp{Proof: op.Proof.ToProto()}
 	bz, err := proto.Marshal(&pbProof)
 	if err != nil {
 		panic(err)
 	}
 	// We length-prefix the byte slice to retain backwards compatibility with the Amino proofs.
-	bz, err = encodeBytesSlice(bz)
+	bz, err = encoding.EncodeBytesSlice(bz)
 	if err != nil {
 		panic(err)
 	}
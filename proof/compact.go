@@ -0,0 +1,357 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/cosmos/iavl/internal/encoding"
+)
+
+// The compact encoding of a RangeProof omits every right sibling hash that the verifier would
+// recompute from the leaves anyway: when a path goes left at an inner node while there are still
+// leaves left to prove, the right sibling is the root of the subtree spanned by the next inner
+// path and its leaves, so its hash can be derived. Only the hashes of subtrees entirely outside
+// the proven range are encoded. For a range of n leaves this drops roughly n-1 hashes.
+//
+// The format is:
+//
+//	byte    format (compactFormatV1)
+//	uvarint number of leaves n
+//	n x     leaf: bytes key, [32]byte value hash, varint version
+//	path    left path
+//	n-1 x   path: inner path for each leaf after the first
+//
+// where a path is a uvarint length followed by that many inner nodes, ordered from the root
+// down like PathToLeaf, each encoded as varint height, varint size, varint version, a sibling
+// byte (siblingLeft, siblingRight or siblingDerived) and the 32-byte sibling hash unless it is
+// derived.
+const compactFormatV1 = 0x01
+
+const (
+	siblingLeft    = 0x00
+	siblingRight   = 0x01
+	siblingDerived = 0x02
+)
+
+// ToCompact encodes the proof using the compact wire format, which omits derivable hashes.
+// It can be decoded with RangeProofFromCompact.
+func (proof *RangeProof) ToCompact() ([]byte, error) {
+	if proof == nil {
+		return nil, errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if len(proof.Leaves) == 0 {
+		return nil, errors.Wrap(ErrInvalidProof, "no leaves")
+	}
+	if len(proof.InnerNodes)+1 != len(proof.Leaves) {
+		return nil, errors.Wrap(ErrInvalidProof, "InnerNodes vs Leaves length mismatch, leaves should be 1 more.")
+	}
+	derived, err := proof.derivedHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteByte(compactFormatV1)
+	err = encoding.EncodeUvarint(buf, uint64(len(proof.Leaves)))
+	if err != nil {
+		return nil, err
+	}
+	for _, leaf := range proof.Leaves {
+		if len(leaf.ValueHash) != sha256.Size {
+			return nil, errors.Wrapf(ErrInvalidProof, "leaf value hash must be %v bytes, got %v",
+				sha256.Size, len(leaf.ValueHash))
+		}
+		err = encoding.EncodeBytes(buf, leaf.Key)
+		if err == nil {
+			_, err = buf.Write(leaf.ValueHash)
+		}
+		if err == nil {
+			err = encoding.EncodeVarint(buf, leaf.Version)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writeCompactPath(buf, proof.LeftPath, derived[0])
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range proof.InnerNodes {
+		err = writeCompactPath(buf, path, derived[i+1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// RangeProofFromCompact decodes a proof encoded with RangeProof.ToCompact, recomputing the
+// omitted hashes. The decoded proof must still be verified against a root hash with Verify.
+func RangeProofFromCompact(bz []byte) (RangeProof, error) {
+	r := compactReader{bz: bz}
+	if format := r.byte(); r.err == nil && format != compactFormatV1 {
+		return RangeProof{}, errors.Errorf("unknown compact proof format %v", format)
+	}
+	numLeaves := r.uvarint()
+	if r.err == nil && (numLeaves == 0 || numLeaves > uint64(len(bz))) {
+		return RangeProof{}, errors.Errorf("invalid number of leaves %v", numLeaves)
+	}
+	if r.err != nil {
+		return RangeProof{}, errors.Wrap(r.err, "decoding compact proof")
+	}
+
+	leaves := make([]ProofLeafNode, 0, numLeaves)
+	for i := uint64(0); i < numLeaves && r.err == nil; i++ {
+		leaves = append(leaves, ProofLeafNode{
+			Key:       r.bytes(),
+			ValueHash: r.fixed(sha256.Size),
+			Version:   r.varint(),
+		})
+	}
+	leftPath, leftDerived := r.path()
+	innerNodes := make([]PathToLeaf, 0, numLeaves-1)
+	innerDerived := make([][]bool, 0, numLeaves-1)
+	for i := uint64(1); i < numLeaves && r.err == nil; i++ {
+		path, derived := r.path()
+		innerNodes = append(innerNodes, path)
+		innerDerived = append(innerDerived, derived)
+	}
+	if r.err != nil {
+		return RangeProof{}, errors.Wrap(r.err, "decoding compact proof")
+	}
+	if len(r.bz) > 0 {
+		return RangeProof{}, errors.Errorf("decoding compact proof: %v unexpected trailing bytes", len(r.bz))
+	}
+
+	// Recompute the derived hashes bottom-up, consuming leaves and inner paths in the same
+	// order as RangeProof.Verify.
+	nextLeaf, nextInner := 0, 0
+	var fill func(path PathToLeaf, derived []bool) (hash []byte, done bool, err error)
+	fill = func(path PathToLeaf, derived []bool) ([]byte, bool, error) {
+		leaf := leaves[nextLeaf]
+		nextLeaf++
+		done := nextLeaf == len(leaves)
+		i := len(path) - 1
+		for ; i >= 0 && !done; i-- {
+			if len(path[i].Right) == 0 && !derived[i] {
+				continue
+			}
+			if nextInner >= len(innerNodes) {
+				return nil, false, errors.New("not enough inner paths")
+			}
+			nextInner++
+			inner, innerDone, err := fill(innerNodes[nextInner-1], innerDerived[nextInner-1])
+			if err != nil {
+				return nil, false, err
+			}
+			if derived[i] {
+				path[i].Right = inner
+			}
+			done = innerDone
+		}
+		for ; i >= 0; i-- {
+			if derived[i] {
+				return nil, false, errors.New("derived hash outside of proven range")
+			}
+		}
+		return path.computeRootHash(leaf.Hash()), done, nil
+	}
+	_, done, err := fill(leftPath, leftDerived)
+	if err != nil {
+		return RangeProof{}, errors.Wrap(err, "decoding compact proof")
+	}
+	if !done || nextInner != len(innerNodes) {
+		return RangeProof{}, errors.New("decoding compact proof: left over leaves")
+	}
+
+	proof := RangeProof{
+		LeftPath: leftPath,
+		Leaves:   leaves,
+	}
+	if len(innerNodes) > 0 {
+		proof.InnerNodes = innerNodes
+	}
+	return proof, nil
+}
+
+// derivedHashes returns, for the left path followed by each inner path, which inner nodes have a
+// right sibling hash that RangeProof.Verify recomputes from the remaining leaves.
+func (proof *RangeProof) derivedHashes() ([][]bool, error) {
+	derived := make([][]bool, 0, len(proof.InnerNodes)+1)
+	derived = append(derived, make([]bool, len(proof.LeftPath)))
+	for _, path := range proof.InnerNodes {
+		derived = append(derived, make([]bool, len(path)))
+	}
+
+	nextLeaf, nextInner := 0, 0
+	var walk func(pathIdx int, path PathToLeaf) bool
+	walk = func(pathIdx int, path PathToLeaf) (done bool) {
+		nextLeaf++
+		done = nextLeaf == len(proof.Leaves)
+		for i := len(path) - 1; i >= 0 && !done && nextInner < len(proof.InnerNodes); i-- {
+			if len(path[i].Right) == 0 {
+				continue
+			}
+			derived[pathIdx][i] = true
+			nextInner++
+			done = walk(nextInner, proof.InnerNodes[nextInner-1])
+		}
+		return done
+	}
+	if !walk(0, proof.LeftPath) {
+		return nil, errors.Wrap(ErrInvalidProof, "left over leaves -- malformed proof")
+	}
+	return derived, nil
+}
+
+func writeCompactPath(buf *bytes.Buffer, path PathToLeaf, derived []bool) error {
+	err := encoding.EncodeUvarint(buf, uint64(len(path)))
+	if err != nil {
+		return err
+	}
+	for i, pin := range path {
+		err = encoding.EncodeVarint(buf, int64(pin.Height))
+		if err == nil {
+			err = encoding.EncodeVarint(buf, pin.Size)
+		}
+		if err == nil {
+			err = encoding.EncodeVarint(buf, pin.Version)
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(pin.Left) > 0 && len(pin.Right) > 0:
+			return errors.Wrap(ErrInvalidProof, "inner node has both left and right hashes")
+		case derived[i]:
+			buf.WriteByte(siblingDerived)
+			continue
+		case len(pin.Left) > 0:
+			buf.WriteByte(siblingLeft)
+			_, err = buf.Write(pin.Left)
+		default:
+			buf.WriteByte(siblingRight)
+			_, err = buf.Write(pin.Right)
+		}
+		if err != nil {
+			return err
+		}
+		if len(pin.Left)+len(pin.Right) != sha256.Size {
+			return errors.Wrapf(ErrInvalidProof, "inner node hash must be %v bytes, got %v",
+				sha256.Size, len(pin.Left)+len(pin.Right))
+		}
+	}
+	return nil
+}
+
+// compactReader decodes the compact proof format, keeping the first error it encounters.
+type compactReader struct {
+	bz  []byte
+	err error
+}
+
+func (r *compactReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.bz) == 0 {
+		r.err = errors.New("unexpected end of input")
+		return 0
+	}
+	b := r.bz[0]
+	r.bz = r.bz[1:]
+	return b
+}
+
+func (r *compactReader) fixed(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.bz) < size {
+		r.err = errors.New("unexpected end of input")
+		return nil
+	}
+	bz := r.bz[:size:size]
+	r.bz = r.bz[size:]
+	return bz
+}
+
+func (r *compactReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	bz, n, err := encoding.DecodeBytes(r.bz)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	r.bz = r.bz[n:]
+	return bz
+}
+
+func (r *compactReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	i, n, err := encoding.DecodeVarint(r.bz)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.bz = r.bz[n:]
+	return i
+}
+
+func (r *compactReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	u, n, err := encoding.DecodeUvarint(r.bz)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.bz = r.bz[n:]
+	return u
+}
+
+func (r *compactReader) path() (PathToLeaf, []bool) {
+	length := r.uvarint()
+	if r.err == nil && length > uint64(len(r.bz)) {
+		r.err = errors.Errorf("invalid path length %v", length)
+	}
+	if r.err != nil || length == 0 {
+		return nil, nil
+	}
+	path := make(PathToLeaf, 0, length)
+	derived := make([]bool, 0, length)
+	for i := uint64(0); i < length && r.err == nil; i++ {
+		height := r.varint()
+		if r.err == nil && (height > math.MaxInt8 || height < math.MinInt8) {
+			r.err = errors.Errorf("height must fit inside an int8, got %v", height)
+		}
+		pin := ProofInnerNode{
+			Height:  int8(height),
+			Size:    r.varint(),
+			Version: r.varint(),
+		}
+		sibling := r.byte()
+		switch sibling {
+		case siblingLeft:
+			pin.Left = r.fixed(sha256.Size)
+		case siblingRight:
+			pin.Right = r.fixed(sha256.Size)
+		case siblingDerived:
+		default:
+			if r.err == nil {
+				r.err = errors.Errorf("invalid sibling type %v", sibling)
+			}
+		}
+		path = append(path, pin)
+		derived = append(derived, sibling == siblingDerived)
+	}
+	return path, derived
+}
@@ -0,0 +1,220 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+
+	ics23 "github.com/confio/ics23/go"
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/pkg/errors"
+
+	cmn "github.com/cosmos/iavl/common"
+)
+
+// The canonical JSON encoding of range proofs is an alternative to their default encoding/json
+// form, which is kept unchanged for compatibility with existing clients and stored proofs. Fields
+// are always emitted in the same order, byte slices are upper-case hex strings, 64-bit integers
+// are decimal strings (so that they survive JavaScript clients), and empty paths are encoded as []
+// rather than null. Decoding accepts hex strings in either case, but rejects unknown fields,
+// trailing data and malformed nodes.
+
+type jsonProofInnerNode struct {
+	Height  int8         `json:"height"`
+	Size    int64        `json:"size,string"`
+	Version int64        `json:"version,string"`
+	Left    cmn.HexBytes `json:"left"`
+	Right   cmn.HexBytes `json:"right"`
+}
+
+type jsonProofLeafNode struct {
+	Key       cmn.HexBytes `json:"key"`
+	ValueHash cmn.HexBytes `json:"value"`
+	Version   int64        `json:"version,string"`
+}
+
+type jsonRangeProof struct {
+	LeftPath   []jsonProofInnerNode   `json:"left_path"`
+	InnerNodes [][]jsonProofInnerNode `json:"inner_nodes"`
+	Leaves     []jsonProofLeafNode    `json:"leaves"`
+}
+
+// MarshalCanonicalJSON encodes a range proof in the canonical JSON form, which is decoded with
+// UnmarshalCanonicalJSON.
+func MarshalCanonicalJSON(proof *RangeProof) ([]byte, error) {
+	if proof == nil {
+		return nil, errors.New("range proof must be set")
+	}
+	jproof := jsonRangeProof{
+		LeftPath:   pathToJSON(proof.LeftPath),
+		InnerNodes: make([][]jsonProofInnerNode, 0, len(proof.InnerNodes)),
+		Leaves:     make([]jsonProofLeafNode, 0, len(proof.Leaves)),
+	}
+	for _, path := range proof.InnerNodes {
+		jproof.InnerNodes = append(jproof.InnerNodes, pathToJSON(path))
+	}
+	for _, leaf := range proof.Leaves {
+		jproof.Leaves = append(jproof.Leaves, jsonProofLeafNode{
+			Key:       nonNilBytes(leaf.Key),
+			ValueHash: nonNilBytes(leaf.ValueHash),
+			Version:   leaf.Version,
+		})
+	}
+	return json.Marshal(jproof)
+}
+
+// UnmarshalCanonicalJSON decodes a range proof encoded with MarshalCanonicalJSON. An empty path is
+// decoded as nil, like in RangeProofFromProto. The decoded proof must still be verified against a
+// root hash with Verify.
+func UnmarshalCanonicalJSON(data []byte) (*RangeProof, error) {
+	var jproof jsonRangeProof
+	if err := unmarshalJSONStrict(data, &jproof); err != nil {
+		return nil, errors.Wrap(err, "invalid range proof JSON")
+	}
+	if len(jproof.Leaves) == 0 {
+		return nil, errors.New("invalid range proof JSON: proof must contain at least one leaf")
+	}
+	if len(jproof.InnerNodes) != len(jproof.Leaves)-1 {
+		return nil, errors.Errorf("invalid range proof JSON: expected %v inner paths for %v leaves, got %v",
+			len(jproof.Leaves)-1, len(jproof.Leaves), len(jproof.InnerNodes))
+	}
+
+	proof := &RangeProof{}
+	var err error
+	if proof.LeftPath, err = pathFromJSON(jproof.LeftPath); err != nil {
+		return nil, err
+	}
+	for _, jpath := range jproof.InnerNodes {
+		path, err := pathFromJSON(jpath)
+		if err != nil {
+			return nil, err
+		}
+		proof.InnerNodes = append(proof.InnerNodes, path)
+	}
+	for i, jleaf := range jproof.Leaves {
+		if len(jleaf.ValueHash) != sha256.Size {
+			return nil, errors.Errorf("invalid leaf node JSON: value hash must be %v bytes, got %v",
+				sha256.Size, len(jleaf.ValueHash))
+		}
+		if i > 0 && bytes.Compare(jproof.Leaves[i-1].Key, jleaf.Key) >= 0 {
+			return nil, errors.Errorf("invalid range proof JSON: leaf keys must be strictly increasing (leaf %v)", i)
+		}
+		proof.Leaves = append(proof.Leaves, ProofLeafNode{
+			Key:       jleaf.Key,
+			ValueHash: jleaf.ValueHash,
+			Version:   jleaf.Version,
+		})
+	}
+	return proof, nil
+}
+
+func pathToJSON(path PathToLeaf) []jsonProofInnerNode {
+	jpath := make([]jsonProofInnerNode, 0, len(path))
+	for _, pin := range path {
+		jpath = append(jpath, jsonProofInnerNode{
+			Height:  pin.Height,
+			Size:    pin.Size,
+			Version: pin.Version,
+			Left:    nonNilBytes(pin.Left),
+			Right:   nonNilBytes(pin.Right),
+		})
+	}
+	return jpath
+}
+
+func pathFromJSON(jpath []jsonProofInnerNode) (PathToLeaf, error) {
+	if jpath == nil {
+		return nil, errors.New("invalid path JSON: expected an array")
+	}
+	var path PathToLeaf
+	for _, jpin := range jpath {
+		if jpin.Height <= 0 {
+			return nil, errors.Errorf("invalid inner node JSON: height must be positive, got %v", jpin.Height)
+		}
+		if jpin.Size < 2 {
+			return nil, errors.Errorf("invalid inner node JSON: size must be at least 2, got %v", jpin.Size)
+		}
+		if (len(jpin.Left) == 0) == (len(jpin.Right) == 0) {
+			return nil, errors.New("invalid inner node JSON: exactly one of left and right must be set")
+		}
+		for _, hash := range [][]byte{jpin.Left, jpin.Right} {
+			if len(hash) != 0 && len(hash) != sha256.Size {
+				return nil, errors.Errorf("invalid inner node JSON: child hash must be %v bytes, got %v",
+					sha256.Size, len(hash))
+			}
+		}
+		path = append(path, ProofInnerNode{
+			Height:  jpin.Height,
+			Size:    jpin.Size,
+			Version: jpin.Version,
+			Left:    emptyToNil(jpin.Left),
+			Right:   emptyToNil(jpin.Right),
+		})
+	}
+	return path, nil
+}
+
+// MarshalCommitmentProofJSON encodes an ICS23 commitment proof as JSON. ICS23 proofs are Protobuf
+// messages shared with other ICS23 implementations, so they use the canonical Protobuf JSON
+// mapping, as served by the gRPC gateway: fields have their original names and are emitted in
+// field number order, byte slices are base64 strings and 64-bit integers are decimal strings.
+func MarshalCommitmentProofJSON(proof *ics23.CommitmentProof) ([]byte, error) {
+	if proof == nil || proof.Proof == nil {
+		return nil, errors.New("ICS23 proof must be set")
+	}
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, proof); err != nil {
+		return nil, errors.Wrap(err, "failed to encode ICS23 proof JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalCommitmentProofJSON decodes an ICS23 commitment proof encoded with
+// MarshalCommitmentProofJSON. It rejects unknown fields, trailing data and empty proofs. The
+// decoded proof must still be verified, e.g. with ics23.VerifyMembership.
+func UnmarshalCommitmentProofJSON(data []byte) (*ics23.CommitmentProof, error) {
+	var raw json.RawMessage
+	if err := unmarshalJSONStrict(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid ICS23 proof JSON")
+	}
+	proof := &ics23.CommitmentProof{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(raw), proof); err != nil {
+		return nil, errors.Wrap(err, "invalid ICS23 proof JSON")
+	}
+	if proof.Proof == nil {
+		return nil, errors.New("invalid ICS23 proof JSON: proof must be set")
+	}
+	return proof, nil
+}
+
+// unmarshalJSONStrict decodes a single JSON value, rejecting unknown fields and trailing data.
+func unmarshalJSONStrict(data []byte, v interface{}) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return errors.New("unexpected null")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// nonNilBytes makes sure that empty byte slices are encoded as "" rather than null.
+func nonNilBytes(bz []byte) cmn.HexBytes {
+	if bz == nil {
+		return cmn.HexBytes{}
+	}
+	return bz
+}
+
+// emptyToNil decodes empty byte slices as nil, like the Protobuf decoder does.
+func emptyToNil(bz []byte) []byte {
+	if len(bz) == 0 {
+		return nil
+	}
+	return bz
+}
@@ -0,0 +1,145 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+)
+
+var emptyHash = sha256.New().Sum(nil)
+
+// PageToken is the continuation token of a paginated range query. The next page is requested
+// starting at Key, against the same tree Version.
+type PageToken struct {
+	Key     []byte `json:"key"`
+	Version int64  `json:"version,string"`
+}
+
+// RangePage is one page of a paginated range query, along with a proof that it contains every
+// key in the tree from the start of the page up to its last key, or up to the end of the queried
+// range for the last page.
+type RangePage struct {
+	Keys   [][]byte    `json:"keys"`
+	Values [][]byte    `json:"values"`
+	Proof  *RangeProof `json:"proof"`
+	// Next is nil for the last page.
+	Next *PageToken `json:"next"`
+}
+
+// PageSuccessor returns the key that the page after key starts at, i.e. the smallest key
+// greater than key.
+func PageSuccessor(key []byte) []byte {
+	next := make([]byte, len(key)+1)
+	copy(next, key)
+	return next
+}
+
+// VerifyRangePages verifies a full sequence of pages for the range [start, end) against root,
+// and returns their keys and values. The first page must start at start, each page must start
+// at the continuation token of the previous one, and together the pages must prove that no key
+// in the range is missing. A nil start or end means the range is unbounded on that side.
+func VerifyRangePages(root, start, end []byte, pages []*RangePage) (keys, values [][]byte, err error) {
+	if len(pages) == 0 {
+		return nil, nil, errors.Wrap(ErrInvalidInputs, "no pages")
+	}
+	cursor := start
+	version := int64(-1)
+	for i, page := range pages {
+		if page == nil {
+			return nil, nil, errors.Wrapf(ErrInvalidInputs, "page %v is nil", i)
+		}
+		last := i == len(pages)-1
+		if last != (page.Next == nil) {
+			if last {
+				return nil, nil, errors.Wrap(ErrInvalidProof, "last page has a continuation token")
+			}
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "page %v has no continuation token", i)
+		}
+		if err := page.verify(root, cursor, end); err != nil {
+			return nil, nil, errors.Wrapf(err, "page %v", i)
+		}
+		keys = append(keys, page.Keys...)
+		values = append(values, page.Values...)
+		if page.Next != nil {
+			if version >= 0 && page.Next.Version != version {
+				return nil, nil, errors.Wrapf(ErrInvalidProof, "page %v is for version %v, expected %v",
+					i, page.Next.Version, version)
+			}
+			version = page.Next.Version
+			cursor = page.Next.Key
+		}
+	}
+	return keys, values, nil
+}
+
+// verify checks the page against root for the range [start, end). If the page has a
+// continuation token, the proof must cover the range up to the last key of the page, otherwise
+// it must cover the whole range.
+func (page *RangePage) verify(root, start, end []byte) error {
+	if len(page.Keys) != len(page.Values) {
+		return errors.Wrap(ErrInvalidProof, "keys and values length mismatch")
+	}
+	if page.Proof == nil {
+		// Only an empty tree has no proof. Its hash is the hash of an empty input.
+		if !bytes.Equal(root, emptyHash) || len(page.Keys) > 0 || page.Next != nil {
+			return errors.Wrap(ErrInvalidProof, "proof is nil")
+		}
+		return nil
+	}
+	proof := page.Proof
+	if err := proof.Verify(root); err != nil {
+		return err
+	}
+	leaves := proof.Leaves
+
+	// The leaves of a range proof are adjacent in the tree, so the proof covers every key from
+	// the first to the last leaf. It must reach back to start.
+	if start != nil && bytes.Compare(leaves[0].Key, start) > 0 && !proof.LeftPath.isLeftmost() {
+		return errors.Wrap(ErrInvalidProof, "proof does not cover the start of the page")
+	}
+
+	// The page must contain the leaves in [start, end), in order, up to its last key.
+	var inRange []ProofLeafNode
+	for _, leaf := range leaves {
+		if start != nil && bytes.Compare(leaf.Key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(leaf.Key, end) >= 0 {
+			break
+		}
+		inRange = append(inRange, leaf)
+	}
+	if len(page.Keys) > len(inRange) {
+		return errors.Wrap(ErrInvalidProof, "page has keys that are not in the proof")
+	}
+	for i, key := range page.Keys {
+		if !bytes.Equal(key, inRange[i].Key) {
+			return errors.Wrapf(ErrInvalidProof, "expected key %X at position %v, got %X", inRange[i].Key, i, key)
+		}
+		if err := proof.VerifyItem(key, page.Values[i]); err != nil {
+			return errors.Wrapf(err, "key %X", key)
+		}
+	}
+
+	if page.Next != nil {
+		if len(page.Keys) == 0 {
+			return errors.Wrap(ErrInvalidProof, "page with a continuation token has no keys")
+		}
+		if !bytes.Equal(page.Next.Key, PageSuccessor(page.Keys[len(page.Keys)-1])) {
+			return errors.Wrap(ErrInvalidProof, "continuation token does not follow the last key")
+		}
+		return nil
+	}
+
+	// The last page must contain every leaf in the range, and the proof must reach past end, or
+	// up to a leaf that no key can follow before end.
+	if len(page.Keys) != len(inRange) {
+		return errors.Wrap(ErrInvalidProof, "last page is missing keys")
+	}
+	lastLeaf := leaves[len(leaves)-1]
+	if !proof.treeEnd && (end == nil || bytes.Compare(PageSuccessor(lastLeaf.Key), end) < 0) {
+		return errors.Wrap(ErrInvalidProof, "proof does not cover the end of the range")
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/cosmos/iavl/proto/proofpb"
+)
+
+type RangeProof struct {
+	// You don't need the right path because
+	// it can be derived from what we have.
+	LeftPath   PathToLeaf      `json:"left_path"`
+	InnerNodes []PathToLeaf    `json:"inner_nodes"`
+	Leaves     []ProofLeafNode `json:"leaves"`
+
+	// memoize
+	rootHash     []byte // valid iff rootVerified is true
+	rootVerified bool
+	treeEnd      bool // valid iff rootVerified is true
+}
+
+// Keys returns all the keys in the RangeProof.  NOTE: The keys here may
+// include more keys than provided by tree.GetRangeWithProof or
+// MutableTree.GetVersionedRangeWithProof.  The keys returned there are only
+// in the provided [startKey,endKey){limit} range.  The keys returned here may
+// include extra keys, such as:
+// - the key before startKey if startKey is provided and doesn't exist;
+// - the key after a queried key with tree.GetWithProof, when the key is absent.
+func (proof *RangeProof) Keys() (keys [][]byte) {
+	if proof == nil {
+		return nil
+	}
+	for _, leaf := range proof.Leaves {
+		keys = append(keys, leaf.Key)
+	}
+	return keys
+}
+
+// String returns a string representation of the proof.
+func (proof *RangeProof) String() string {
+	if proof == nil {
+		return "<nil-RangeProof>"
+	}
+	return proof.StringIndented("")
+}
+
+func (proof *RangeProof) StringIndented(indent string) string {
+	istrs := make([]string, 0, len(proof.InnerNodes))
+	for _, ptl := range proof.InnerNodes {
+		istrs = append(istrs, ptl.stringIndented(indent+"    "))
+	}
+	lstrs := make([]string, 0, len(proof.Leaves))
+	for _, leaf := range proof.Leaves {
+		lstrs = append(lstrs, leaf.stringIndented(indent+"    "))
+	}
+	return fmt.Sprintf(`RangeProof{
+%s  LeftPath: %v
+%s  InnerNodes:
+%s    %v
+%s  Leaves:
+%s    %v
+%s  (rootVerified): %v
+%s  (rootHash): %X
+%s  (treeEnd): %v
+%s}`,
+		indent, proof.LeftPath.stringIndented(indent+"  "),
+		indent,
+		indent, strings.Join(istrs, "\n"+indent+"    "),
+		indent,
+		indent, strings.Join(lstrs, "\n"+indent+"    "),
+		indent, proof.rootVerified,
+		indent, proof.rootHash,
+		indent, proof.treeEnd,
+		indent)
+}
+
+// The index of the first leaf (of the whole tree).
+// Returns -1 if the proof is nil.
+func (proof *RangeProof) LeftIndex() int64 {
+	if proof == nil {
+		return -1
+	}
+	return proof.LeftPath.Index()
+}
+
+// Also see LeftIndex().
+// Verify that a key has some value.
+// Does not assume that the proof itself is valid, call Verify() first.
+func (proof *RangeProof) VerifyItem(key, value []byte) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if !proof.rootVerified {
+		return errors.New("must call Verify(root) first")
+	}
+	leaves := proof.Leaves
+	i := sort.Search(len(leaves), func(i int) bool {
+		return bytes.Compare(key, leaves[i].Key) <= 0
+	})
+	if i >= len(leaves) || !bytes.Equal(leaves[i].Key, key) {
+		return errors.Wrap(ErrInvalidProof, "leaf key not found in proof")
+	}
+
+	h := sha256.Sum256(value)
+	valueHash := h[:]
+	if !bytes.Equal(leaves[i].ValueHash, valueHash) {
+		return errors.Wrap(ErrInvalidProof, "leaf value hash not same")
+	}
+
+	return nil
+}
+
+// VerifyItemAtIndex verifies that the key at the given index of the tree has some value, as
+// proven by ImmutableTree.GetProofByIndex(). The index is derived from the subtree sizes along
+// LeftPath, which are committed to by the root hash.
+// Does not assume that the proof itself is valid, call Verify() first.
+func (proof *RangeProof) VerifyItemAtIndex(index int64, key, value []byte) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if !proof.rootVerified {
+		return errors.New("must call Verify(root) first")
+	}
+	if len(proof.Leaves) == 0 || !bytes.Equal(proof.Leaves[0].Key, key) {
+		return errors.Wrap(ErrInvalidProof, "leaf key not first in proof")
+	}
+	if leftIndex := proof.LeftIndex(); leftIndex != index {
+		return errors.Wrapf(ErrInvalidProof, "leaf index %v does not match expected index %v", leftIndex, index)
+	}
+	return proof.VerifyItem(key, value)
+}
+
+// VerifyRank returns the number of keys in the tree that are smaller than the given key, i.e. the
+// index at which the key is or would be stored. The proof must contain the key, or both leaves
+// surrounding it, as returned by ImmutableTree.GetRangeCountProof().
+// Does not assume that the proof itself is valid, call Verify() first.
+func (proof *RangeProof) VerifyRank(key []byte) (int64, error) {
+	if proof == nil {
+		return -1, errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if !proof.rootVerified {
+		return -1, errors.New("must call Verify(root) first")
+	}
+	leftIndex := proof.LeftIndex()
+	if leftIndex < 0 {
+		return -1, errors.Wrap(ErrInvalidProof, "invalid left path")
+	}
+	leaves := proof.Leaves
+	i := sort.Search(len(leaves), func(i int) bool {
+		return bytes.Compare(key, leaves[i].Key) <= 0
+	})
+	switch {
+	case i == 0 && !bytes.Equal(leaves[0].Key, key) && leftIndex != 0:
+		return -1, errors.Wrap(ErrInvalidProof, "rank not proved by left path")
+	case i == len(leaves) && !proof.treeEnd:
+		return -1, errors.Wrap(ErrInvalidProof, "rank not proved by right leaf")
+	}
+	return leftIndex + int64(i), nil
+}
+
+// treeSize returns the number of keys in the tree, as committed to by the root of the left path.
+// Does not assume that the proof itself is valid, call Verify() first.
+func (proof *RangeProof) treeSize() int64 {
+	if len(proof.LeftPath) == 0 {
+		return 1
+	}
+	return proof.LeftPath[0].Size
+}
+
+// Verify that proof is valid absence proof for key.
+// Does not assume that the proof itself is valid.
+// For that, use Verify(root).
+func (proof *RangeProof) VerifyAbsence(key []byte) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if !proof.rootVerified {
+		return errors.New("must call Verify(root) first")
+	}
+	cmp := bytes.Compare(key, proof.Leaves[0].Key)
+	if cmp < 0 {
+		if proof.LeftPath.isLeftmost() {
+			return nil
+		}
+		return errors.New("absence not proved by left path")
+
+	} else if cmp == 0 {
+		return errors.New("absence disproved via first item #0")
+	}
+	if len(proof.LeftPath) == 0 {
+		return nil // proof ok
+	}
+	if proof.LeftPath.isRightmost() {
+		return nil
+	}
+
+	// See if any of the leaves are greater than key.
+	for i := 1; i < len(proof.Leaves); i++ {
+		leaf := proof.Leaves[i]
+		cmp := bytes.Compare(key, leaf.Key)
+		switch {
+		case cmp < 0:
+			return nil // proof ok
+		case cmp == 0:
+			return errors.New(fmt.Sprintf("absence disproved via item #%v", i))
+		default:
+			// if i == len(proof.Leaves)-1 {
+			// If last item, check whether
+			// it's the last item in the tree.
+
+			// }
+			continue
+		}
+	}
+
+	// It's still a valid proof if our last leaf is the rightmost child.
+	if proof.treeEnd {
+		return nil // OK!
+	}
+
+	// It's not a valid absence proof.
+	if len(proof.Leaves) < 2 {
+		return errors.New("absence not proved by right leaf (need another leaf?)")
+	}
+	return errors.New("absence not proved by right leaf")
+
+}
+
+// Verify that proof is valid.
+func (proof *RangeProof) Verify(root []byte) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	err := proof.verify(root)
+	return err
+}
+
+func (proof *RangeProof) verify(root []byte) (err error) {
+	rootHash := proof.rootHash
+	if rootHash == nil {
+		derivedHash, err := proof.computeRootHash()
+		if err != nil {
+			return err
+		}
+		rootHash = derivedHash
+	}
+	if !bytes.Equal(rootHash, root) {
+		return errors.Wrap(ErrInvalidRoot, "root hash doesn't match")
+	}
+	proof.rootVerified = true
+	return nil
+}
+
+// ComputeRootHash computes the root hash with leaves.
+// Returns nil if error or proof is nil.
+// Does not verify the root hash.
+func (proof *RangeProof) ComputeRootHash() []byte {
+	if proof == nil {
+		return nil
+	}
+	rootHash, _ := proof.computeRootHash()
+	return rootHash
+}
+
+func (proof *RangeProof) computeRootHash() (rootHash []byte, err error) {
+	rootHash, treeEnd, err := proof._computeRootHash()
+	if err == nil {
+		proof.rootHash = rootHash // memoize
+		proof.treeEnd = treeEnd   // memoize
+	}
+	return rootHash, err
+}
+
+func (proof *RangeProof) _computeRootHash() (rootHash []byte, treeEnd bool, err error) {
+	if len(proof.Leaves) == 0 {
+		return nil, false, errors.Wrap(ErrInvalidProof, "no leaves")
+	}
+	if len(proof.InnerNodes)+1 != len(proof.Leaves) {
+		return nil, false, errors.Wrap(ErrInvalidProof, "InnerNodes vs Leaves length mismatch, leaves should be 1 more.")
+	}
+
+	// Start from the left path and prove each leaf.
+
+	// shared across recursive calls
+	var leaves = proof.Leaves
+	var innersq = proof.InnerNodes
+	var COMPUTEHASH func(path PathToLeaf, rightmost bool) (hash []byte, treeEnd bool, done bool, err error)
+
+	// rightmost: is the root a rightmost child of the tree?
+	// treeEnd: true iff the last leaf is the last item of the tree.
+	// Returns the (possibly intermediate, possibly root) hash.
+	COMPUTEHASH = func(path PathToLeaf, rightmost bool) (hash []byte, treeEnd bool, done bool, err error) {
+
+		// Pop next leaf.
+		nleaf, rleaves := leaves[0], leaves[1:]
+		leaves = rleaves
+
+		// Compute hash.
+		hash = (pathWithLeaf{
+			Path: path,
+			Leaf: nleaf,
+		}).computeRootHash()
+
+		// If we don't have any leaves left, we're done.
+		if len(leaves) == 0 {
+			rightmost = rightmost && path.isRightmost()
+			return hash, rightmost, true, nil
+		}
+
+		// Prove along path (until we run out of leaves).
+		for len(path) > 0 {
+
+			// Drop the leaf-most (last-most) inner nodes from path
+			// until we encounter one with a left hash.
+			// We assume that the left side is already verified.
+			// rpath: rest of path
+			// lpath: last path item
+			rpath, lpath := path[:len(path)-1], path[len(path)-1]
+			path = rpath
+			if len(lpath.Right) == 0 {
+				continue
+			}
+
+			// Pop next inners, a PathToLeaf (e.g. []ProofInnerNode).
+			inners, rinnersq := innersq[0], innersq[1:]
+			innersq = rinnersq
+
+			// Recursively verify inners against remaining leaves.
+			derivedRoot, treeEnd, done, err := COMPUTEHASH(inners, rightmost && rpath.isRightmost())
+			if err != nil {
+				return nil, treeEnd, false, errors.Wrap(err, "recursive COMPUTEHASH call")
+			}
+			if !bytes.Equal(derivedRoot, lpath.Right) {
+				return nil, treeEnd, false, errors.Wrapf(ErrInvalidRoot, "intermediate root hash %X doesn't match, got %X", lpath.Right, derivedRoot)
+			}
+			if done {
+				return hash, treeEnd, true, nil
+			}
+		}
+
+		// We're not done yet (leaves left over). No error, not done either.
+		// Technically if rightmost, we know there's an error "left over leaves
+		// -- malformed proof", but we return that at the top level, below.
+		return hash, false, false, nil
+	}
+
+	// Verify!
+	path := proof.LeftPath
+	rootHash, treeEnd, done, err := COMPUTEHASH(path, true)
+	if err != nil {
+		return nil, treeEnd, errors.Wrap(err, "root COMPUTEHASH call")
+	} else if !done {
+		return nil, treeEnd, errors.Wrap(ErrInvalidProof, "left over leaves -- malformed proof")
+	}
+
+	// Ok!
+	return rootHash, treeEnd, nil
+}
+
+// toProto converts the proof to a Protobuf representation, for use in ValueOp and AbsenceOp.
+func (proof *RangeProof) ToProto() *proofpb.RangeProof {
+	pb := &proofpb.RangeProof{
+		LeftPath:   make([]*proofpb.ProofInnerNode, 0, len(proof.LeftPath)),
+		InnerNodes: make([]*proofpb.PathToLeaf, 0, len(proof.InnerNodes)),
+		Leaves:     make([]*proofpb.ProofLeafNode, 0, len(proof.Leaves)),
+	}
+	for _, inner := range proof.LeftPath {
+		pb.LeftPath = append(pb.LeftPath, inner.toProto())
+	}
+	for _, path := range proof.InnerNodes {
+		pbPath := make([]*proofpb.ProofInnerNode, 0, len(path))
+		for _, inner := range path {
+			pbPath = append(pbPath, inner.toProto())
+		}
+		pb.InnerNodes = append(pb.InnerNodes, &proofpb.PathToLeaf{Inners: pbPath})
+	}
+	for _, leaf := range proof.Leaves {
+		pb.Leaves = append(pb.Leaves, leaf.toProto())
+	}
+
+	return pb
+}
+
+// rangeProofFromProto generates a RangeProof from a Protobuf RangeProof.
+func RangeProofFromProto(pbProof *proofpb.RangeProof) (RangeProof, error) {
+	proof := RangeProof{}
+
+	for _, pbInner := range pbProof.LeftPath {
+		inner, err := proofInnerNodeFromProto(pbInner)
+		if err != nil {
+			return proof, err
+		}
+		proof.LeftPath = append(proof.LeftPath, inner)
+	}
+
+	for _, pbPath := range pbProof.InnerNodes {
+		var path PathToLeaf // leave as nil unless populated, for Amino compatibility
+		if pbPath != nil {
+			for _, pbInner := range pbPath.Inners {
+				inner, err := proofInnerNodeFromProto(pbInner)
+				if err != nil {
+					return proof, err
+				}
+				path = append(path, inner)
+			}
+		}
+		proof.InnerNodes = append(proof.InnerNodes, path)
+	}
+
+	for _, pbLeaf := range pbProof.Leaves {
+		leaf, err := proofLeafNodeFromProto(pbLeaf)
+		if err != nil {
+			return proof, err
+		}
+		proof.Leaves = append(proof.Leaves, leaf)
+	}
+	return proof, nil
+}
@@ -0,0 +1,55 @@
+package proof
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// RangeCountProof proves the number of keys in a key range [start, end), without including the
+// keys themselves. It consists of proofs of the ranks of both range bounds, which are derived from
+// the subtree sizes committed to by the inner nodes along their paths.
+type RangeCountProof struct {
+	// StartProof proves the rank of the start key. It is nil when the range has no start bound.
+	StartProof *RangeProof `json:"start_proof"`
+	// EndProof proves the rank of the end key. When the range has no end bound, it is an arbitrary
+	// proof used to verify the size of the tree.
+	EndProof *RangeProof `json:"end_proof"`
+}
+
+// VerifyCount verifies the proof against the given root hash and returns the number of keys in the
+// range [start, end). Either bound may be nil, in which case the range is open on that side.
+func (proof *RangeCountProof) VerifyCount(root []byte, start, end []byte) (int64, error) {
+	if proof == nil || proof.EndProof == nil {
+		return -1, errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		return -1, errors.Wrap(ErrInvalidInputs, "start key is after end key")
+	}
+
+	var startRank int64
+	if start != nil {
+		if err := proof.StartProof.Verify(root); err != nil {
+			return -1, errors.Wrap(err, "verifying start proof")
+		}
+		rank, err := proof.StartProof.VerifyRank(start)
+		if err != nil {
+			return -1, errors.Wrap(err, "verifying start rank")
+		}
+		startRank = rank
+	}
+
+	if err := proof.EndProof.Verify(root); err != nil {
+		return -1, errors.Wrap(err, "verifying end proof")
+	}
+	endRank := proof.EndProof.treeSize()
+	if end != nil {
+		rank, err := proof.EndProof.VerifyRank(end)
+		if err != nil {
+			return -1, errors.Wrap(err, "verifying end rank")
+		}
+		endRank = rank
+	}
+
+	return endRank - startRank, nil
+}
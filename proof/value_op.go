@@ -1,4 +1,4 @@
-package iavl
+package proof
 
 import (
 	"fmt"
@@ -8,7 +8,8 @@ import (
 	"github.com/tendermint/tendermint/crypto/merkle"
 	tmmerkle "github.com/tendermint/tendermint/proto/tendermint/crypto"
 
-	iavlproto "github.com/cosmos/iavl/proto"
+	"github.com/cosmos/iavl/internal/encoding"
+	"github.com/cosmos/iavl/proto/proofpb"
 )
 
 const ProofOpIAVLValue = "iavl:v"
@@ -42,14 +43,14 @@ func ValueOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
 		return nil, errors.Errorf("unexpected ProofOp.Type; got %v, want %v", pop.Type, ProofOpIAVLValue)
 	}
 	// Strip the varint length prefix, used for backwards compatibility with Amino.
-	bz, n, err := decodeBytes(pop.Data)
+	bz, n, err := encoding.DecodeBytes(pop.Data)
 	if err != nil {
 		return nil, err
 	}
 	if n != len(pop.Data) {
 		return nil, fmt.Errorf("unexpected bytes, expected %v got %v", n, len(pop.Data))
 	}
-	pbProofOp := &iavlproto.ValueOp{}
+	pbProofOp := &proofpb.ValueOp{}
 	err = proto.Unmarshal(bz, pbProofOp)
 	if err != nil {
 		return nil, err
@@ -62,13 +63,13 @@ func ValueOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
 }
 
 func (op ValueOp) ProofOp() tmmerkle.ProofOp {
-	pbProof := iavlproto.ValueOp{Proof: op.Proof.ToProto()}
+	pbProof := proofpb.ValueOp{Proof: op.Proof.ToProto()}
 	bz, err := proto.Marshal(&pbProof)
 	if err != nil {
 		panic(err)
 	}
 	// We length-prefix the byte slice to retain backwards compatibility with the Amino proofs.
-	bz, err = encodeBytesSlice(bz)
+	bz, err = encoding.EncodeBytesSlice(bz)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,51 @@
+package proof
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmmerkle "github.com/tendermint/tendermint/proto/tendermint/crypto"
+)
+
+// Proofs for a tree holding keys {0x0a, 0x11, 0x2e, 0x32, 0x50, 0x72, 0x99, 0xa1, 0xe4, 0xf7},
+// where each value equals its key. They are verified here without access to the tree.
+const (
+	testRoot          = "0f804861e051c7cb7949afb131c7e5986f80be2968dfecf0b2577a4d31c4b3b2"
+	testValueProof11  = "aa010aa7010a280808100a18012a2022b4e34a1778d6a03aac39f00d89deb886e0cc37454e300b7aebeb4f4939c0790a280804100418012a20734fad809673ab2b9672453a8b2bc8c9591e2d1d97933df5b4c3b0531bf82e720a28080210021801222053d2828f35e33aecab8e411a40afb0475288973b96aed2220e9894f43a5375ad1a270a011112204a64a107f0cb32536e5bce6c98c393db21cca7f4ea187ba8c4dca8b51d4ea80a1801"
+	testAbsenceProof0 = "aa010aa7010a280808100a18012a2022b4e34a1778d6a03aac39f00d89deb886e0cc37454e300b7aebeb4f4939c0790a280804100418012a20734fad809673ab2b9672453a8b2bc8c9591e2d1d97933df5b4c3b0531bf82e720a280802100218012a20154b101a72acffe0f5e65d1e144a57dc6f97758d2049821231f02b6a5b44fe811a270a010a122001ba4719c80b6fe911b091a7c05124b64eeece964e09c058ef8f9805daca546b1801"
+)
+
+func TestValueOpRun(t *testing.T) {
+	root, err := hex.DecodeString(testRoot)
+	require.NoError(t, err)
+	data, err := hex.DecodeString(testValueProof11)
+	require.NoError(t, err)
+
+	op, err := ValueOpDecoder(tmmerkle.ProofOp{Type: ProofOpIAVLValue, Key: []byte{0x11}, Data: data})
+	require.NoError(t, err)
+
+	res, err := op.Run([][]byte{{0x11}})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{root}, res)
+
+	_, err = op.Run([][]byte{{0x12}})
+	require.Error(t, err)
+}
+
+func TestAbsenceOpRun(t *testing.T) {
+	root, err := hex.DecodeString(testRoot)
+	require.NoError(t, err)
+	data, err := hex.DecodeString(testAbsenceProof0)
+	require.NoError(t, err)
+
+	op, err := AbsenceOpDecoder(tmmerkle.ProofOp{Type: ProofOpIAVLAbsence, Key: []byte{0x00}, Data: data})
+	require.NoError(t, err)
+
+	res, err := op.Run(nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{root}, res)
+
+	_, err = ValueOpDecoder(tmmerkle.ProofOp{Type: ProofOpIAVLAbsence, Key: []byte{0x00}, Data: data})
+	require.Error(t, err)
+}
@@ -0,0 +1,34 @@
+package iavl
+
+import (
+	"github.com/tendermint/tendermint/crypto/merkle"
+	tmmerkle "github.com/tendermint/tendermint/proto/tendermint/crypto"
+
+	iavlproof "github.com/cosmos/iavl/proof"
+)
+
+const (
+	ProofOpIAVLValue   = iavlproof.ProofOpIAVLValue
+	ProofOpIAVLAbsence = iavlproof.ProofOpIAVLAbsence
+)
+
+type (
+	ValueOp   = iavlproof.ValueOp
+	AbsenceOp = iavlproof.AbsenceOp
+)
+
+func NewValueOp(key []byte, proof *RangeProof) ValueOp {
+	return iavlproof.NewValueOp(key, proof)
+}
+
+func ValueOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
+	return iavlproof.ValueOpDecoder(pop)
+}
+
+func NewAbsenceOp(key []byte, proof *RangeProof) AbsenceOp {
+	return iavlproof.NewAbsenceOp(key, proof)
+}
+
+func AbsenceOpDecoder(pop tmmerkle.ProofOp) (merkle.ProofOperator, error) {
+	return iavlproof.AbsenceOpDecoder(pop)
+}
@@ -1,10 +1,13 @@
 package iavl
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 
 	ics23 "github.com/confio/ics23/go"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -29,40 +32,208 @@ GetNonMembershipProof will produce a CommitmentProof that the given key doesn't
 If the key exists in the tree, this will return an error.
 */
 func (t *ImmutableTree) GetNonMembershipProof(key []byte) (*ics23.CommitmentProof, error) {
-	// idx is one node right of what we want....
-	idx, val := t.Get(key)
-	if val != nil {
-		return nil, fmt.Errorf("cannot create NonExistanceProof when Key in State")
+	nonexist, err := createNonExistenceProof(t, key)
+	if err != nil {
+		return nil, err
+	}
+	proof := &ics23.CommitmentProof{
+		Proof: &ics23.CommitmentProof_Nonexist{
+			Nonexist: nonexist,
+		},
+	}
+	return proof, nil
+}
+
+/*
+GetBatchProof will produce a single compressed CommitmentProof for all of the given keys. Keys that
+exist in the iavl tree get an existence proof, while keys that don't get a non-existence proof. Inner
+nodes shared between the paths of the keys are only included once.
+
+The result can be verified with ics23.BatchVerifyMembership and ics23.BatchVerifyNonMembership.
+*/
+func (t *ImmutableTree) GetBatchProof(keys [][]byte) (*ics23.CommitmentProof, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cannot create BatchProof without keys")
+	}
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	entries := make([]*ics23.BatchEntry, 0, len(sorted))
+	for i, key := range sorted {
+		if i > 0 && bytes.Equal(key, sorted[i-1]) {
+			continue
+		}
+		if t.Has(key) {
+			exist, err := createExistenceProof(t, key)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &ics23.BatchEntry{
+				Proof: &ics23.BatchEntry_Exist{
+					Exist: exist,
+				},
+			})
+		} else {
+			nonexist, err := createNonExistenceProof(t, key)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &ics23.BatchEntry{
+				Proof: &ics23.BatchEntry_Nonexist{
+					Nonexist: nonexist,
+				},
+			})
+		}
+	}
+
+	proof := &ics23.CommitmentProof{
+		Proof: &ics23.CommitmentProof_Batch{
+			Batch: &ics23.BatchProof{
+				Entries: entries,
+			},
+		},
 	}
+	return ics23.Compress(proof), nil
+}
+
+/*
+GetVersionedMembershipProof will produce a CommitmentProof that the given key exists in the iavl tree
+at the given version. The version is registered as having an active reader while the proof is built,
+so it can't be deleted concurrently.
+*/
+func (tree *MutableTree) GetVersionedMembershipProof(key []byte, version int64) (*ics23.CommitmentProof, error) {
+	t, release, err := tree.getVersionForReading(version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.GetMembershipProof(key)
+}
 
-	var err error
+/*
+GetVersionedNonMembershipProof will produce a CommitmentProof that the given key doesn't exist in the
+iavl tree at the given version. Like GetVersionedMembershipProof, the version can't be deleted while
+the proof is built.
+*/
+func (tree *MutableTree) GetVersionedNonMembershipProof(key []byte, version int64) (*ics23.CommitmentProof, error) {
+	t, release, err := tree.getVersionForReading(version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.GetNonMembershipProof(key)
+}
+
+// getVersionForReading loads an ImmutableTree at the given version and registers an active reader
+// for it. The caller must call the returned release function when done. The reader is registered
+// before checking that the version exists, so that it can't be deleted in between.
+func (tree *MutableTree) getVersionForReading(version int64) (*ImmutableTree, func(), error) {
+	tree.ndb.incrVersionReaders(version)
+	release := func() {
+		tree.ndb.decrVersionReaders(version)
+	}
+	if !tree.VersionExists(version) {
+		release()
+		return nil, nil, errors.Wrap(ErrVersionDoesNotExist, "")
+	}
+	t, err := tree.GetImmutable(version)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return t, release, nil
+}
+
+// createNonExistenceProof builds the existence proofs for both neighbors of an absent key in a
+// single descent from the root. The descent ends at one of the neighbors, and the other one is
+// found below the last node where the descent branched away from it.
+func createNonExistenceProof(tree *ImmutableTree, key []byte) (*ics23.NonExistenceProof, error) {
 	nonexist := &ics23.NonExistenceProof{
 		Key: key,
 	}
+	if tree.root == nil {
+		return nonexist, nil
+	}
+	tree.root.hashWithCount() // Ensure that all hashes are calculated.
 
-	if idx >= 1 {
-		leftkey, _ := t.GetByIndex(idx - 1)
-		nonexist.Left, err = createExistenceProof(t, leftkey)
-		if err != nil {
-			return nil, err
+	var (
+		path  PathToLeaf
+		nodes []*Node
+		// indexes into path of the last nodes where we went left or right, if any.
+		lastLeft, lastRight = -1, -1
+	)
+	node := tree.root
+	for !node.isLeaf() {
+		nodes = append(nodes, node)
+		if bytes.Compare(key, node.key) < 0 {
+			lastLeft = len(path)
+			path = append(path, ProofInnerNode{
+				Height:  node.height,
+				Size:    node.size,
+				Version: node.version,
+				Left:    nil,
+				Right:   node.getRightNode(tree).hash,
+			})
+			node = node.getLeftNode(tree)
+		} else {
+			lastRight = len(path)
+			path = append(path, ProofInnerNode{
+				Height:  node.height,
+				Size:    node.size,
+				Version: node.version,
+				Left:    node.getLeftNode(tree).hash,
+				Right:   nil,
+			})
+			node = node.getRightNode(tree)
 		}
 	}
 
-	// this will be nil if nothing right of the queried key
-	rightkey, _ := t.GetByIndex(idx)
-	if rightkey != nil {
-		nonexist.Right, err = createExistenceProof(t, rightkey)
-		if err != nil {
-			return nil, err
+	switch bytes.Compare(node.key, key) {
+	case 0:
+		return nil, fmt.Errorf("cannot create NonExistanceProof when Key in State")
+	case -1:
+		nonexist.Left = convertLeafPath(node, path)
+		if lastLeft >= 0 {
+			nonexist.Right = createNeighborExistenceProof(tree, path[:lastLeft], nodes[lastLeft], false)
+		}
+	case 1:
+		nonexist.Right = convertLeafPath(node, path)
+		if lastRight >= 0 {
+			nonexist.Left = createNeighborExistenceProof(tree, path[:lastRight], nodes[lastRight], true)
 		}
 	}
+	return nonexist, nil
+}
 
-	proof := &ics23.CommitmentProof{
-		Proof: &ics23.CommitmentProof_Nonexist{
-			Nonexist: nonexist,
-		},
+// createNeighborExistenceProof builds an existence proof for a neighbor of an absent key, given
+// the path to the inner node where the descent towards the key branched away from the neighbor.
+// The left neighbor is the rightmost leaf of the node's left subtree, and the right neighbor is the
+// leftmost leaf of its right subtree.
+func createNeighborExistenceProof(tree *ImmutableTree, prefix PathToLeaf, branch *Node, leftNeighbor bool) *ics23.ExistenceProof {
+	path := make(PathToLeaf, len(prefix), len(prefix)+int(branch.height))
+	copy(path, prefix)
+
+	node := branch
+	for i := 0; !node.isLeaf(); i++ {
+		pin := ProofInnerNode{
+			Height:  node.height,
+			Size:    node.size,
+			Version: node.version,
+		}
+		// At the branching node we step towards the neighbor, then follow the opposite edge.
+		if leftNeighbor == (i == 0) {
+			pin.Right = node.getRightNode(tree).hash
+			node = node.getLeftNode(tree)
+		} else {
+			pin.Left = node.getLeftNode(tree).hash
+			node = node.getRightNode(tree)
+		}
+		path = append(path, pin)
 	}
-	return proof, nil
+	return convertLeafPath(node, path)
 }
 
 func createExistenceProof(tree *ImmutableTree, key []byte) (*ics23.ExistenceProof, error) {
@@ -93,6 +264,16 @@ func convertExistenceProof(p *RangeProof, key, value []byte) (*ics23.ExistencePr
 	}, nil
 }
 
+// convertLeafPath will convert a leaf node and the path to it into an existence proof.
+func convertLeafPath(leaf *Node, path PathToLeaf) *ics23.ExistenceProof {
+	return &ics23.ExistenceProof{
+		Key:   leaf.key,
+		Value: leaf.value,
+		Leaf:  convertLeafOp(leaf.version),
+		Path:  convertInnerOps(path),
+	}
+}
+
 func convertLeafOp(version int64) *ics23.LeafOp {
 	var varintBuf [binary.MaxVarintLen64]byte
 	// this is adapted from iavl/proof.go:proofLeafNode.Hash()
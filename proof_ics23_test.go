@@ -11,6 +11,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	db "github.com/tendermint/tm-db"
+
+	iavlproof "github.com/cosmos/iavl/proof"
 )
 
 func TestConvertExistence(t *testing.T) {
@@ -92,6 +94,199 @@ func TestGetNonMembership(t *testing.T) {
 	}
 }
 
+func TestGetNonMembershipNeighbors(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 100, 5431} {
+		tree, allkeys, err := BuildTree(size)
+		require.NoError(t, err, "Creating tree: %+v", err)
+		root := tree.Hash()
+
+		nonkeys := [][]byte{{0, 0, 0, 0}, {0xff, 0xff, 0xff, 0xff, 0xff}}
+		for i := 0; i < 50 && i < size; i++ {
+			key := append([]byte{}, allkeys[rand.Intn(size)]...) // nolint:gosec
+			nonkeys = append(nonkeys, append(key, 0))
+		}
+
+		for _, key := range nonkeys {
+			if tree.Has(key) {
+				continue
+			}
+			proof, err := tree.GetNonMembershipProof(key)
+			require.NoError(t, err)
+			require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, proof, key))
+
+			// The neighbors must match those found by index lookups.
+			idx, _ := tree.Get(key)
+			nonexist := proof.GetNonexist()
+			if idx >= 1 {
+				leftkey, _ := tree.GetByIndex(idx - 1)
+				expected, err := createExistenceProof(tree, leftkey)
+				require.NoError(t, err)
+				require.Equal(t, expected, nonexist.Left)
+			} else {
+				require.Nil(t, nonexist.Left)
+			}
+			if rightkey, _ := tree.GetByIndex(idx); rightkey != nil {
+				expected, err := createExistenceProof(tree, rightkey)
+				require.NoError(t, err)
+				require.Equal(t, expected, nonexist.Right)
+			} else {
+				require.Nil(t, nonexist.Right)
+			}
+		}
+
+		_, err = tree.GetNonMembershipProof(allkeys[0])
+		require.Error(t, err)
+	}
+}
+
+func TestGetBatchProof(t *testing.T) {
+	tree, allkeys, err := BuildTree(5431)
+	require.NoError(t, err, "Creating tree: %+v", err)
+	root := tree.Hash()
+
+	items := map[string][]byte{}
+	var present, absent [][]byte
+	for _, loc := range []Where{Left, Right, Middle, Middle, Middle} {
+		key := GetKey(allkeys, loc)
+		_, items[string(key)] = tree.Get(key)
+		present = append(present, key)
+		absent = append(absent, GetNonKey(allkeys, loc))
+	}
+	// include a duplicate key, it must only be proven once
+	keys := append(append(present, absent...), present[0])
+	unique := map[string]bool{}
+	for _, key := range keys {
+		unique[string(key)] = true
+	}
+
+	proof, err := tree.GetBatchProof(keys)
+	require.NoError(t, err, "Creating Proof: %+v", err)
+	require.True(t, ics23.IsCompressed(proof))
+	require.Len(t, ics23.Decompress(proof).GetBatch().Entries, len(unique))
+
+	require.True(t, ics23.BatchVerifyMembership(ics23.IavlSpec, root, proof, items), "Batch Membership Proof Invalid")
+	require.True(t, ics23.BatchVerifyNonMembership(ics23.IavlSpec, root, proof, absent), "Batch Non Membership Proof Invalid")
+	require.False(t, ics23.BatchVerifyNonMembership(ics23.IavlSpec, root, proof, present[:1]))
+
+	// the compressed proof must be smaller than the individual proofs
+	size := 0
+	for _, key := range present {
+		single, err := tree.GetMembershipProof(key)
+		require.NoError(t, err)
+		size += single.Size()
+	}
+	for _, key := range absent {
+		single, err := tree.GetNonMembershipProof(key)
+		require.NoError(t, err)
+		size += single.Size()
+	}
+	require.Less(t, proof.Size(), size)
+
+	_, err = tree.GetBatchProof(nil)
+	require.Error(t, err)
+}
+
+func TestGetVersionedMembership(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	tree.Set([]byte("a"), []byte("1"))
+	tree.Set([]byte("c"), []byte("1"))
+	root1, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	tree.Set([]byte("a"), []byte("2"))
+	tree.Set([]byte("b"), []byte("2"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	proof, err := tree.GetVersionedMembershipProof([]byte("a"), 1)
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyMembership(ics23.IavlSpec, root1, proof, []byte("a"), []byte("1")))
+
+	proof, err = tree.GetVersionedNonMembershipProof([]byte("b"), 1)
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root1, proof, []byte("b")))
+
+	_, err = tree.GetVersionedNonMembershipProof([]byte("b"), 2)
+	require.Error(t, err)
+	_, err = tree.GetVersionedMembershipProof([]byte("a"), 3)
+	require.Error(t, err)
+
+	// readers are released after the proofs are built, or when the version is missing
+	require.Zero(t, tree.ndb.versionReaders[1])
+	require.Zero(t, tree.ndb.versionReaders[3])
+	require.NoError(t, tree.DeleteVersion(1))
+}
+
+func TestCommitmentProofJSON(t *testing.T) {
+	tree, allkeys, err := BuildTree(200)
+	require.NoError(t, err)
+	root := tree.Hash()
+	key, nonKey := GetKey(allkeys, Middle), GetNonKey(allkeys, Middle)
+	_, value := tree.Get(key)
+
+	exist, err := tree.GetMembershipProof(key)
+	require.NoError(t, err)
+	nonexist, err := tree.GetNonMembershipProof(nonKey)
+	require.NoError(t, err)
+	batch, err := tree.GetBatchProof([][]byte{key, nonKey})
+	require.NoError(t, err)
+
+	for _, proof := range []*ics23.CommitmentProof{exist, nonexist, batch} {
+		bz, err := iavlproof.MarshalCommitmentProofJSON(proof)
+		require.NoError(t, err)
+		decoded, err := iavlproof.UnmarshalCommitmentProofJSON(bz)
+		require.NoError(t, err)
+		require.Equal(t, proof, decoded)
+
+		// Encoding is canonical.
+		bz2, err := iavlproof.MarshalCommitmentProofJSON(decoded)
+		require.NoError(t, err)
+		require.Equal(t, string(bz), string(bz2))
+	}
+
+	bz, err := iavlproof.MarshalCommitmentProofJSON(exist)
+	require.NoError(t, err)
+	require.Contains(t, string(bz), `{"exist":{"key":"`)
+	decoded, err := iavlproof.UnmarshalCommitmentProofJSON(bz)
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyMembership(ics23.IavlSpec, root, decoded, key, value))
+
+	_, err = iavlproof.MarshalCommitmentProofJSON(&ics23.CommitmentProof{})
+	require.Error(t, err)
+	for json, msg := range map[string]string{
+		`null`:                     "unexpected null",
+		`{}`:                       "proof must be set",
+		`{"exist":{"foo":1}}`:      "unknown field",
+		`{"exist":{}} {}`:          "unexpected data",
+		`{"exist":{"key":"!!!"}}`:  "invalid ICS23 proof JSON",
+		`{"exist":{"key":"AA=="}}`: "",
+	} {
+		_, err := iavlproof.UnmarshalCommitmentProofJSON([]byte(json))
+		if msg == "" {
+			require.NoError(t, err, json)
+			continue
+		}
+		require.Error(t, err, json)
+		require.Contains(t, err.Error(), msg, json)
+	}
+}
+
+func BenchmarkGetNonMembership(b *testing.B) {
+	tree, allkeys, err := BuildTree(100000)
+	require.NoError(b, err)
+	tree.Hash()
+	nonkeys := make([][]byte, 1000)
+	for i := range nonkeys {
+		nonkeys[i] = GetNonKey(allkeys, Middle)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := tree.GetNonMembershipProof(nonkeys[i%len(nonkeys)])
+		require.NoError(b, err)
+	}
+}
+
 // Test Helpers
 
 // Result is the result of one match
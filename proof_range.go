@@ -3,367 +3,21 @@ package iavl
 import (
 	"bytes"
 	"crypto/sha256"
-	"fmt"
-	"sort"
-	"strings"
 
 	"github.com/pkg/errors"
 
+	iavlproof "github.com/cosmos/iavl/proof"
 	iavlproto "github.com/cosmos/iavl/proto"
 )
 
-type RangeProof struct {
-	// You don't need the right path because
-	// it can be derived from what we have.
-	LeftPath   PathToLeaf      `json:"left_path"`
-	InnerNodes []PathToLeaf    `json:"inner_nodes"`
-	Leaves     []ProofLeafNode `json:"leaves"`
-
-	// memoize
-	rootHash     []byte // valid iff rootVerified is true
-	rootVerified bool
-	treeEnd      bool // valid iff rootVerified is true
-}
-
-// Keys returns all the keys in the RangeProof.  NOTE: The keys here may
-// include more keys than provided by tree.GetRangeWithProof or
-// MutableTree.GetVersionedRangeWithProof.  The keys returned there are only
-// in the provided [startKey,endKey){limit} range.  The keys returned here may
-// include extra keys, such as:
-// - the key before startKey if startKey is provided and doesn't exist;
-// - the key after a queried key with tree.GetWithProof, when the key is absent.
-func (proof *RangeProof) Keys() (keys [][]byte) {
-	if proof == nil {
-		return nil
-	}
-	for _, leaf := range proof.Leaves {
-		keys = append(keys, leaf.Key)
-	}
-	return keys
-}
-
-// String returns a string representation of the proof.
-func (proof *RangeProof) String() string {
-	if proof == nil {
-		return "<nil-RangeProof>"
-	}
-	return proof.StringIndented("")
-}
-
-func (proof *RangeProof) StringIndented(indent string) string {
-	istrs := make([]string, 0, len(proof.InnerNodes))
-	for _, ptl := range proof.InnerNodes {
-		istrs = append(istrs, ptl.stringIndented(indent+"    "))
-	}
-	lstrs := make([]string, 0, len(proof.Leaves))
-	for _, leaf := range proof.Leaves {
-		lstrs = append(lstrs, leaf.stringIndented(indent+"    "))
-	}
-	return fmt.Sprintf(`RangeProof{
-%s  LeftPath: %v
-%s  InnerNodes:
-%s    %v
-%s  Leaves:
-%s    %v
-%s  (rootVerified): %v
-%s  (rootHash): %X
-%s  (treeEnd): %v
-%s}`,
-		indent, proof.LeftPath.stringIndented(indent+"  "),
-		indent,
-		indent, strings.Join(istrs, "\n"+indent+"    "),
-		indent,
-		indent, strings.Join(lstrs, "\n"+indent+"    "),
-		indent, proof.rootVerified,
-		indent, proof.rootHash,
-		indent, proof.treeEnd,
-		indent)
-}
-
-// The index of the first leaf (of the whole tree).
-// Returns -1 if the proof is nil.
-func (proof *RangeProof) LeftIndex() int64 {
-	if proof == nil {
-		return -1
-	}
-	return proof.LeftPath.Index()
-}
-
-// Also see LeftIndex().
-// Verify that a key has some value.
-// Does not assume that the proof itself is valid, call Verify() first.
-func (proof *RangeProof) VerifyItem(key, value []byte) error {
-	if proof == nil {
-		return errors.Wrap(ErrInvalidProof, "proof is nil")
-	}
-	if !proof.rootVerified {
-		return errors.New("must call Verify(root) first")
-	}
-	leaves := proof.Leaves
-	i := sort.Search(len(leaves), func(i int) bool {
-		return bytes.Compare(key, leaves[i].Key) <= 0
-	})
-	if i >= len(leaves) || !bytes.Equal(leaves[i].Key, key) {
-		return errors.Wrap(ErrInvalidProof, "leaf key not found in proof")
-	}
-
-	h := sha256.Sum256(value)
-	valueHash := h[:]
-	if !bytes.Equal(leaves[i].ValueHash, valueHash) {
-		return errors.Wrap(ErrInvalidProof, "leaf value hash not same")
-	}
-
-	return nil
-}
-
-// Verify that proof is valid absence proof for key.
-// Does not assume that the proof itself is valid.
-// For that, use Verify(root).
-func (proof *RangeProof) VerifyAbsence(key []byte) error {
-	if proof == nil {
-		return errors.Wrap(ErrInvalidProof, "proof is nil")
-	}
-	if !proof.rootVerified {
-		return errors.New("must call Verify(root) first")
-	}
-	cmp := bytes.Compare(key, proof.Leaves[0].Key)
-	if cmp < 0 {
-		if proof.LeftPath.isLeftmost() {
-			return nil
-		}
-		return errors.New("absence not proved by left path")
-
-	} else if cmp == 0 {
-		return errors.New("absence disproved via first item #0")
-	}
-	if len(proof.LeftPath) == 0 {
-		return nil // proof ok
-	}
-	if proof.LeftPath.isRightmost() {
-		return nil
-	}
-
-	// See if any of the leaves are greater than key.
-	for i := 1; i < len(proof.Leaves); i++ {
-		leaf := proof.Leaves[i]
-		cmp := bytes.Compare(key, leaf.Key)
-		switch {
-		case cmp < 0:
-			return nil // proof ok
-		case cmp == 0:
-			return errors.New(fmt.Sprintf("absence disproved via item #%v", i))
-		default:
-			// if i == len(proof.Leaves)-1 {
-			// If last item, check whether
-			// it's the last item in the tree.
-
-			// }
-			continue
-		}
-	}
-
-	// It's still a valid proof if our last leaf is the rightmost child.
-	if proof.treeEnd {
-		return nil // OK!
-	}
-
-	// It's not a valid absence proof.
-	if len(proof.Leaves) < 2 {
-		return errors.New("absence not proved by right leaf (need another leaf?)")
-	}
-	return errors.New("absence not proved by right leaf")
-
-}
-
-// Verify that proof is valid.
-func (proof *RangeProof) Verify(root []byte) error {
-	if proof == nil {
-		return errors.Wrap(ErrInvalidProof, "proof is nil")
-	}
-	err := proof.verify(root)
-	return err
-}
-
-func (proof *RangeProof) verify(root []byte) (err error) {
-	rootHash := proof.rootHash
-	if rootHash == nil {
-		derivedHash, err := proof.computeRootHash()
-		if err != nil {
-			return err
-		}
-		rootHash = derivedHash
-	}
-	if !bytes.Equal(rootHash, root) {
-		return errors.Wrap(ErrInvalidRoot, "root hash doesn't match")
-	}
-	proof.rootVerified = true
-	return nil
-}
-
-// ComputeRootHash computes the root hash with leaves.
-// Returns nil if error or proof is nil.
-// Does not verify the root hash.
-func (proof *RangeProof) ComputeRootHash() []byte {
-	if proof == nil {
-		return nil
-	}
-	rootHash, _ := proof.computeRootHash()
-	return rootHash
-}
-
-func (proof *RangeProof) computeRootHash() (rootHash []byte, err error) {
-	rootHash, treeEnd, err := proof._computeRootHash()
-	if err == nil {
-		proof.rootHash = rootHash // memoize
-		proof.treeEnd = treeEnd   // memoize
-	}
-	return rootHash, err
-}
-
-func (proof *RangeProof) _computeRootHash() (rootHash []byte, treeEnd bool, err error) {
-	if len(proof.Leaves) == 0 {
-		return nil, false, errors.Wrap(ErrInvalidProof, "no leaves")
-	}
-	if len(proof.InnerNodes)+1 != len(proof.Leaves) {
-		return nil, false, errors.Wrap(ErrInvalidProof, "InnerNodes vs Leaves length mismatch, leaves should be 1 more.")
-	}
-
-	// Start from the left path and prove each leaf.
-
-	// shared across recursive calls
-	var leaves = proof.Leaves
-	var innersq = proof.InnerNodes
-	var COMPUTEHASH func(path PathToLeaf, rightmost bool) (hash []byte, treeEnd bool, done bool, err error)
-
-	// rightmost: is the root a rightmost child of the tree?
-	// treeEnd: true iff the last leaf is the last item of the tree.
-	// Returns the (possibly intermediate, possibly root) hash.
-	COMPUTEHASH = func(path PathToLeaf, rightmost bool) (hash []byte, treeEnd bool, done bool, err error) {
-
-		// Pop next leaf.
-		nleaf, rleaves := leaves[0], leaves[1:]
-		leaves = rleaves
-
-		// Compute hash.
-		hash = (pathWithLeaf{
-			Path: path,
-			Leaf: nleaf,
-		}).computeRootHash()
-
-		// If we don't have any leaves left, we're done.
-		if len(leaves) == 0 {
-			rightmost = rightmost && path.isRightmost()
-			return hash, rightmost, true, nil
-		}
-
-		// Prove along path (until we run out of leaves).
-		for len(path) > 0 {
-
-			// Drop the leaf-most (last-most) inner nodes from path
-			// until we encounter one with a left hash.
-			// We assume that the left side is already verified.
-			// rpath: rest of path
-			// lpath: last path item
-			rpath, lpath := path[:len(path)-1], path[len(path)-1]
-			path = rpath
-			if len(lpath.Right) == 0 {
-				continue
-			}
-
-			// Pop next inners, a PathToLeaf (e.g. []ProofInnerNode).
-			inners, rinnersq := innersq[0], innersq[1:]
-			innersq = rinnersq
-
-			// Recursively verify inners against remaining leaves.
-			derivedRoot, treeEnd, done, err := COMPUTEHASH(inners, rightmost && rpath.isRightmost())
-			if err != nil {
-				return nil, treeEnd, false, errors.Wrap(err, "recursive COMPUTEHASH call")
-			}
-			if !bytes.Equal(derivedRoot, lpath.Right) {
-				return nil, treeEnd, false, errors.Wrapf(ErrInvalidRoot, "intermediate root hash %X doesn't match, got %X", lpath.Right, derivedRoot)
-			}
-			if done {
-				return hash, treeEnd, true, nil
-			}
-		}
-
-		// We're not done yet (leaves left over). No error, not done either.
-		// Technically if rightmost, we know there's an error "left over leaves
-		// -- malformed proof", but we return that at the top level, below.
-		return hash, false, false, nil
-	}
-
-	// Verify!
-	path := proof.LeftPath
-	rootHash, treeEnd, done, err := COMPUTEHASH(path, true)
-	if err != nil {
-		return nil, treeEnd, errors.Wrap(err, "root COMPUTEHASH call")
-	} else if !done {
-		return nil, treeEnd, errors.Wrap(ErrInvalidProof, "left over leaves -- malformed proof")
-	}
-
-	// Ok!
-	return rootHash, treeEnd, nil
-}
-
-// toProto converts the proof to a Protobuf representation, for use in ValueOp and AbsenceOp.
-func (proof *RangeProof) ToProto() *iavlproto.RangeProof {
-	pb := &iavlproto.RangeProof{
-		LeftPath:   make([]*iavlproto.ProofInnerNode, 0, len(proof.LeftPath)),
-		InnerNodes: make([]*iavlproto.PathToLeaf, 0, len(proof.InnerNodes)),
-		Leaves:     make([]*iavlproto.ProofLeafNode, 0, len(proof.Leaves)),
-	}
-	for _, inner := range proof.LeftPath {
-		pb.LeftPath = append(pb.LeftPath, inner.toProto())
-	}
-	for _, path := range proof.InnerNodes {
-		pbPath := make([]*iavlproto.ProofInnerNode, 0, len(path))
-		for _, inner := range path {
-			pbPath = append(pbPath, inner.toProto())
-		}
-		pb.InnerNodes = append(pb.InnerNodes, &iavlproto.PathToLeaf{Inners: pbPath})
-	}
-	for _, leaf := range proof.Leaves {
-		pb.Leaves = append(pb.Leaves, leaf.toProto())
-	}
-
-	return pb
-}
-
-// rangeProofFromProto generates a RangeProof from a Protobuf RangeProof.
+// RangeProofFromProto generates a RangeProof from a Protobuf RangeProof.
 func RangeProofFromProto(pbProof *iavlproto.RangeProof) (RangeProof, error) {
-	proof := RangeProof{}
-
-	for _, pbInner := range pbProof.LeftPath {
-		inner, err := proofInnerNodeFromProto(pbInner)
-		if err != nil {
-			return proof, err
-		}
-		proof.LeftPath = append(proof.LeftPath, inner)
-	}
-
-	for _, pbPath := range pbProof.InnerNodes {
-		var path PathToLeaf // leave as nil unless populated, for Amino compatibility
-		if pbPath != nil {
-			for _, pbInner := range pbPath.Inners {
-				inner, err := proofInnerNodeFromProto(pbInner)
-				if err != nil {
-					return proof, err
-				}
-				path = append(path, inner)
-			}
-		}
-		proof.InnerNodes = append(proof.InnerNodes, path)
-	}
+	return iavlproof.RangeProofFromProto(pbProof)
+}
 
-	for _, pbLeaf := range pbProof.Leaves {
-		leaf, err := proofLeafNodeFromProto(pbLeaf)
-		if err != nil {
-			return proof, err
-		}
-		proof.Leaves = append(proof.Leaves, leaf)
-	}
-	return proof, nil
+// RangeProofFromCompact decodes a RangeProof encoded with RangeProof.ToCompact.
+func RangeProofFromCompact(bz []byte) (RangeProof, error) {
+	return iavlproof.RangeProofFromCompact(bz)
 }
 
 // keyStart is inclusive and keyEnd is exclusive.
@@ -409,11 +63,11 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 	}
 
 	// 1: Special case if limit is 1.
-	// 2: Special case if keyEnd is left.key+1.
+	// 2: Special case if no key can follow left.key before keyEnd.
 	_stop := false
 	if limit == 1 {
 		_stop = true // case 1
-	} else if keyEnd != nil && bytes.Compare(cpIncr(left.key), keyEnd) >= 0 {
+	} else if keyEnd != nil && bytes.Compare(iavlproof.PageSuccessor(left.key), keyEnd) >= 0 {
 		_stop = true // case 2
 	}
 	if _stop {
@@ -423,8 +77,9 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 		}, keys, values, nil
 	}
 
-	// Get the key after left.key to iterate from.
-	afterLeft := cpIncr(left.key)
+	// Get the key after left.key to iterate from. This must be the smallest key greater than
+	// left.key, since cpIncr would skip keys that have left.key as a prefix.
+	afterLeft := iavlproof.PageSuccessor(left.key)
 
 	// Traverse starting from afterLeft, until keyEnd or the next leaf
 	// after keyEnd.
@@ -487,7 +142,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 
 				// Terminate if we've found keyEnd-1 or after.
 				// We don't want to fetch any leaves for it.
-				if keyEnd != nil && bytes.Compare(cpIncr(node.key), keyEnd) >= 0 {
+				if keyEnd != nil && bytes.Compare(iavlproof.PageSuccessor(node.key), keyEnd) >= 0 {
 					return true
 				}
 
@@ -522,7 +177,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 // GetWithProof gets the value under the key if it exists, or returns nil.
 // A proof of existence or absence is returned alongside the value.
 func (t *ImmutableTree) GetWithProof(key []byte) (value []byte, proof *RangeProof, err error) {
-	proof, _, values, err := t.getRangeProof(key, cpIncr(key), 2)
+	proof, _, values, err := t.getRangeProof(key, iavlproof.PageSuccessor(key), 2)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "constructing range proof")
 	}
@@ -532,12 +187,79 @@ func (t *ImmutableTree) GetWithProof(key []byte) (value []byte, proof *RangeProo
 	return nil, proof, nil
 }
 
+// GetProofByIndex gets the key and value at the specified index, along with a proof of
+// existence that can be checked with RangeProof.VerifyItemAtIndex(). An error is returned if the
+// index is out of range.
+func (t *ImmutableTree) GetProofByIndex(index int64) (key, value []byte, proof *RangeProof, err error) {
+	if index < 0 || index >= t.Size() {
+		return nil, nil, nil, errors.Errorf("index %v out of range for tree of size %v", index, t.Size())
+	}
+	key, _ = t.GetByIndex(index)
+	value, proof, err = t.GetWithProof(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return key, value, proof, nil
+}
+
 // GetRangeWithProof gets key/value pairs within the specified range and limit.
 func (t *ImmutableTree) GetRangeWithProof(startKey []byte, endKey []byte, limit int) (keys, values [][]byte, proof *RangeProof, err error) {
 	proof, keys, values, err = t.getRangeProof(startKey, endKey, limit)
 	return
 }
 
+// GetRangePageWithProof gets a page of at most limit key/value pairs in the range [startKey,
+// endKey), along with a proof that no key is left out between startKey and the last key of the
+// page. If there are more keys in the range, the page has a continuation token, and the next page
+// is fetched by calling GetVersionedRangePageWithProof with the token's key and version. A full
+// sequence of pages can be checked with VerifyRangePages.
+func (t *ImmutableTree) GetRangePageWithProof(startKey, endKey []byte, limit int) (*RangePage, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf("limit must be positive, got %v", limit)
+	}
+	if startKey != nil && endKey != nil && bytes.Compare(startKey, endKey) >= 0 {
+		return nil, errors.New("startKey must be less than endKey")
+	}
+	if t.root == nil {
+		return &RangePage{}, nil
+	}
+
+	// Fetch the page along with the leaf before startKey (if it doesn't exist), and the leaf after
+	// the page, which tells us whether there is another page, or proves where the range ends.
+	// getRangeProof counts the leaf before startKey towards the limit, and proves the final leaf
+	// without returning it, so we ask for one more leaf than the page, or two more if startKey
+	// doesn't exist.
+	leaves := limit + 1
+	if startKey != nil && !t.Has(startKey) {
+		leaves++
+	}
+	proof, keys, values, err := t.getRangeProof(startKey, endKey, leaves)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing range proof")
+	}
+	n := len(keys)
+	if n > limit {
+		n = limit
+	}
+	page := &RangePage{Proof: proof, Keys: keys[:n], Values: values[:n]}
+
+	// If the final leaf is in range but not in the page, there is another page.
+	last := proof.Leaves[len(proof.Leaves)-1].Key
+	if n > 0 && !bytes.Equal(last, keys[n-1]) && (endKey == nil || bytes.Compare(last, endKey) < 0) {
+		page.Next = &PageToken{
+			Key:     iavlproof.PageSuccessor(keys[n-1]),
+			Version: t.version,
+		}
+	}
+	return page, nil
+}
+
+// VerifyRangePages verifies a sequence of pages returned by GetRangePageWithProof for the range
+// [startKey, endKey) against root, and returns their keys and values.
+func VerifyRangePages(root, startKey, endKey []byte, pages []*RangePage) (keys, values [][]byte, err error) {
+	return iavlproof.VerifyRangePages(root, startKey, endKey, pages)
+}
+
 // GetVersionedWithProof gets the value under the key at the specified version
 // if it exists, or returns nil.
 func (tree *MutableTree) GetVersionedWithProof(key []byte, version int64) ([]byte, *RangeProof, error) {
@@ -566,3 +288,17 @@ func (tree *MutableTree) GetVersionedRangeWithProof(startKey, endKey []byte, lim
 	}
 	return nil, nil, nil, errors.Wrap(ErrVersionDoesNotExist, "")
 }
+
+// GetVersionedRangePageWithProof gets a page of key/value pairs in the range [startKey, endKey) at
+// the specified version. See ImmutableTree.GetRangePageWithProof. Like
+// GetVersionedMembershipProof, the version can't be deleted while the page is built.
+func (tree *MutableTree) GetVersionedRangePageWithProof(startKey, endKey []byte, limit int, version int64) (
+	*RangePage, error) {
+
+	t, release, err := tree.getVersionForReading(version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return t.GetRangePageWithProof(startKey, endKey, limit)
+}
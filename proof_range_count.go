@@ -0,0 +1,45 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// GetRangeCountProof returns the number of keys in the range [start, end), along with a proof of
+// the count. Either bound may be nil, in which case the range is open on that side. Unlike
+// GetRangeWithProof, the proof size does not depend on the number of keys in the range. For an
+// empty tree, the proof is nil.
+func (t *ImmutableTree) GetRangeCountProof(start, end []byte) (count int64, proof *RangeCountProof, err error) {
+	if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		return 0, nil, errors.Wrap(ErrInvalidInputs, "start key is after end key")
+	}
+	if t.root == nil {
+		return 0, nil, nil
+	}
+
+	proof = &RangeCountProof{}
+	if start != nil {
+		proof.StartProof, _, _, err = t.getRangeProof(start, nil, 2)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "constructing start proof")
+		}
+	}
+	if end != nil {
+		proof.EndProof, _, _, err = t.getRangeProof(end, nil, 2)
+	} else {
+		proof.EndProof, _, _, err = t.getRangeProof(nil, nil, 1)
+	}
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "constructing end proof")
+	}
+
+	startIndex, endIndex := int64(0), t.Size()
+	if start != nil {
+		startIndex, _ = t.Get(start)
+	}
+	if end != nil {
+		endIndex, _ = t.Get(end)
+	}
+	return endIndex - startIndex, proof, nil
+}
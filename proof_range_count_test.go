@@ -0,0 +1,77 @@
+package iavl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeCountProof(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+
+	_, proof, err := tree.GetRangeCountProof(nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, proof)
+
+	for i := 0; i < 200; i++ {
+		tree.Set([]byte{byte(i / 16), byte(i % 16 * 2)}, randBytes(4))
+	}
+	root := tree.WorkingHash()
+
+	bounds := [][]byte{nil, {}, {0}, {0, 0}, {0, 1}, {3, 4}, {3, 5}, {7, 30}, {12, 14}, {12, 15}, {12, 16}, {255}}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+				_, _, err := tree.GetRangeCountProof(start, end)
+				require.Error(t, err)
+				continue
+			}
+			var expected int64
+			tree.IterateRange(start, end, true, func(key, value []byte) bool {
+				expected++
+				return false
+			})
+
+			count, proof, err := tree.GetRangeCountProof(start, end)
+			require.NoError(t, err)
+			require.Equal(t, expected, count, "start=%x end=%x", start, end)
+
+			verified, err := proof.VerifyCount(root, start, end)
+			require.NoError(t, err, "start=%x end=%x", start, end)
+			require.Equal(t, expected, verified, "start=%x end=%x", start, end)
+
+			_, err = proof.VerifyCount([]byte("foo"), start, end)
+			require.Error(t, err)
+		}
+	}
+
+	// A proof for a different range must not verify.
+	_, proof, err = tree.GetRangeCountProof([]byte{3, 4}, []byte{7, 30})
+	require.NoError(t, err)
+	_, err = proof.VerifyCount(root, []byte{5}, []byte{7, 30})
+	require.Error(t, err)
+	_, err = proof.VerifyCount(root, []byte{3, 4}, []byte{1})
+	require.Error(t, err)
+	_, err = proof.VerifyCount(root, []byte{3, 4}, []byte{9})
+	require.Error(t, err)
+}
+
+func TestRangeProofVerifyRank(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	tree.Set([]byte{5}, []byte{1})
+	root := tree.WorkingHash()
+
+	for key, rank := range map[byte]int64{4: 0, 5: 0, 6: 1} {
+		proof, _, _, err := tree.getRangeProof([]byte{key}, nil, 2)
+		require.NoError(t, err)
+		_, err = proof.VerifyRank([]byte{key})
+		require.Error(t, err) // Verifying rank before calling Verify(root)
+		require.NoError(t, proof.Verify(root))
+		actual, err := proof.VerifyRank([]byte{key})
+		require.NoError(t, err)
+		require.Equal(t, rank, actual)
+	}
+}
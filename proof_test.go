@@ -3,6 +3,10 @@ package iavl
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	proto "github.com/gogo/protobuf/proto"
@@ -10,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	cmn "github.com/cosmos/iavl/common"
+	iavlproof "github.com/cosmos/iavl/proof"
 	iavlproto "github.com/cosmos/iavl/proto"
 )
 
@@ -48,6 +53,38 @@ func TestTreeGetWithProof(t *testing.T) {
 	require.NoError(err, "%+v", err)
 }
 
+func TestTreeGetProofByIndex(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	require := require.New(t)
+	for i := 0; i < 100; i++ {
+		tree.Set([]byte(cmn.RandStr(12)), []byte(cmn.RandStr(8)))
+	}
+	root := tree.WorkingHash()
+
+	for _, index := range []int64{0, 1, 37, 98, 99} {
+		key, val, proof, err := tree.GetProofByIndex(index)
+		require.NoError(err)
+		expectKey, expectVal := tree.GetByIndex(index)
+		require.Equal(expectKey, key)
+		require.Equal(expectVal, val)
+
+		err = proof.VerifyItemAtIndex(index, key, val)
+		require.Error(err, "%+v", err) // Verifying item before calling Verify(root)
+		err = proof.Verify(root)
+		require.NoError(err, "%+v", err)
+		err = proof.VerifyItemAtIndex(index, key, val)
+		require.NoError(err, "%+v", err)
+		require.Error(proof.VerifyItemAtIndex(index+1, key, val))
+		require.Error(proof.VerifyItemAtIndex(index, key, []byte("wrong")))
+	}
+
+	_, _, _, err = tree.GetProofByIndex(100)
+	require.Error(err)
+	_, _, _, err = tree.GetProofByIndex(-1)
+	require.Error(err)
+}
+
 func TestTreeKeyExistsProof(t *testing.T) {
 	tree, err := getTestTree(0)
 	require.NoError(t, err)
@@ -144,7 +181,7 @@ func TestTreeKeyInRangeProofs(t *testing.T) {
 		{start: 0x0a, end: 0xf8, pkeys: keys[0:T], vals: keys[0:T], lidx: 0}, // #1
 		{start: 0x00, end: 0xff, pkeys: keys[0:T], vals: keys[0:T], lidx: 0}, // #2
 		{start: 0x14, end: 0xe4, pkeys: keys[1:9], vals: keys[2:8], lidx: 1}, // #3
-		{start: 0x14, end: 0xe5, pkeys: keys[1:9], vals: keys[2:9], lidx: 1}, // #4
+		{start: 0x14, end: 0xe5, pkeys: keys[1:T], vals: keys[2:9], lidx: 1}, // #4
 		{start: 0x14, end: 0xe6, pkeys: keys[1:T], vals: keys[2:9], lidx: 1}, // #5
 		{start: 0x14, end: 0xf1, pkeys: keys[1:T], vals: keys[2:9], lidx: 1}, // #6
 		{start: 0x14, end: 0xf7, pkeys: keys[1:T], vals: keys[2:9], lidx: 1}, // #7
@@ -153,7 +190,7 @@ func TestTreeKeyInRangeProofs(t *testing.T) {
 		{start: 0x2e, end: 0x32, pkeys: keys[2:4], vals: keys[2:3], lidx: 2}, // #10
 		{start: 0x2f, end: 0x32, pkeys: keys[2:4], vals: nil______, lidx: 2}, // #11
 		{start: 0x2e, end: 0x31, pkeys: keys[2:4], vals: keys[2:3], lidx: 2}, // #12
-		{start: 0x2e, end: 0x2f, pkeys: keys[2:3], vals: keys[2:3], lidx: 2}, // #13
+		{start: 0x2e, end: 0x2f, pkeys: keys[2:4], vals: keys[2:3], lidx: 2}, // #13
 		{start: 0x12, end: 0x31, pkeys: keys[1:4], vals: keys[2:3], lidx: 1}, // #14
 		{start: 0xf8, end: 0xff, pkeys: keys[9:T], vals: nil______, lidx: 9}, // #15
 		{start: 0x12, end: 0x20, pkeys: keys[1:3], vals: nil______, lidx: 1}, // #16
@@ -259,3 +296,312 @@ func flatten(bzz [][]byte) (res []byte) {
 	}
 	return res
 }
+
+func TestRangeProofJSON(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		tree.Set([]byte(cmn.RandStr(8)), []byte(cmn.RandStr(8)))
+	}
+	root := tree.WorkingHash()
+
+	_, _, proof, err := tree.GetRangeWithProof(nil, nil, 0)
+	require.NoError(t, err)
+	bz, err := iavlproof.MarshalCanonicalJSON(proof)
+	require.NoError(t, err)
+
+	decoded, err := iavlproof.UnmarshalCanonicalJSON(bz)
+	require.NoError(t, err)
+	require.Equal(t, proof.LeftPath, decoded.LeftPath)
+	require.Equal(t, proof.InnerNodes, decoded.InnerNodes)
+	require.Equal(t, proof.Leaves, decoded.Leaves)
+	require.NoError(t, decoded.Verify(root))
+
+	// Encoding is canonical.
+	bz2, err := iavlproof.MarshalCanonicalJSON(decoded)
+	require.NoError(t, err)
+	require.Equal(t, string(bz), string(bz2))
+
+	// The default encoding/json form is unchanged, so proofs encoded by earlier versions still
+	// decode.
+	bz, err = json.Marshal(proof)
+	require.NoError(t, err)
+	var plain RangeProof
+	require.NoError(t, json.Unmarshal(bz, &plain))
+	require.NoError(t, plain.Verify(root))
+	inner := proof.LeftPath[0]
+	innerJSON, err := json.Marshal(inner)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf(`{"height":%v,"size":%v,"version":%v,"left":%s,"right":%s}`,
+		inner.Height, inner.Size, inner.Version, jsonBase64(inner.Left), jsonBase64(inner.Right)),
+		string(innerJSON))
+
+	// Absence proofs.
+	value, proof, err := tree.GetWithProof([]byte("nonexistent"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	bz, err = iavlproof.MarshalCanonicalJSON(proof)
+	require.NoError(t, err)
+	decoded, err = iavlproof.UnmarshalCanonicalJSON(bz)
+	require.NoError(t, err)
+	require.NoError(t, decoded.Verify(root))
+	require.NoError(t, decoded.VerifyAbsence([]byte("nonexistent")))
+	require.Contains(t, string(bz), fmt.Sprintf(`"leaves":[{"key":"%X","value":"%X","version":"%v"}`,
+		proof.Leaves[0].Key, proof.Leaves[0].ValueHash, proof.Leaves[0].Version))
+}
+
+// jsonBase64 returns the default JSON encoding of a byte slice.
+func jsonBase64(bz []byte) string {
+	if bz == nil {
+		return "null"
+	}
+	return `"` + base64.StdEncoding.EncodeToString(bz) + `"`
+}
+
+func TestRangeProofJSONErrors(t *testing.T) {
+	hash := strings.Repeat("AB", 32)
+	leaf := `{"key":"01","value":"` + hash + `","version":"1"}`
+	inner := `{"height":1,"size":"2","version":"1","left":"","right":"` + hash + `"}`
+
+	testcases := map[string]struct {
+		json string
+		err  string
+	}{
+		"valid":            {`{"left_path":[` + inner + `],"inner_nodes":[],"leaves":[` + leaf + `]}`, ""},
+		"null":             {`null`, "unexpected null"},
+		"no leaves":        {`{"left_path":[],"inner_nodes":[],"leaves":[]}`, "at least one leaf"},
+		"unknown field":    {`{"left_path":[],"inner_nodes":[],"leaves":[` + leaf + `],"foo":1}`, "unknown field"},
+		"inner path count": {`{"left_path":[],"inner_nodes":[[]],"leaves":[` + leaf + `]}`, "expected 0 inner paths"},
+		"bad hex": {`{"left_path":[],"inner_nodes":[],"leaves":[{"key":"0g","value":"` + hash +
+			`","version":"1"}]}`, "invalid range proof JSON"},
+		"short value hash": {`{"left_path":[],"inner_nodes":[],"leaves":[{"key":"01","value":"AB","version":"1"}]}`,
+			"value hash must be 32 bytes"},
+		"numeric version": {`{"left_path":[],"inner_nodes":[],"leaves":[{"key":"01","value":"` + hash +
+			`","version":1}]}`, "invalid range proof JSON"},
+		"both children": {`{"left_path":[{"height":1,"size":"2","version":"1","left":"` + hash + `","right":"` +
+			hash + `"}],"inner_nodes":[],"leaves":[` + leaf + `]}`, "exactly one of left and right"},
+		"zero height": {`{"left_path":[{"height":0,"size":"2","version":"1","left":"","right":"` + hash +
+			`"}],"inner_nodes":[],"leaves":[` + leaf + `]}`, "height must be positive"},
+		"null path":     {`{"left_path":null,"inner_nodes":[],"leaves":[` + leaf + `]}`, "expected an array"},
+		"unsorted keys": {`{"left_path":[],"inner_nodes":[[]],"leaves":[` + leaf + `,` + leaf + `]}`, "strictly increasing"},
+		"trailing data": {`{"left_path":[],"inner_nodes":[],"leaves":[` + leaf + `]} {}`, "unexpected data after JSON value"},
+	}
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			_, err := iavlproof.UnmarshalCanonicalJSON([]byte(tc.json))
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestRangeProofCompact(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	keys := make([][]byte, 0, 300)
+	for i := 0; i < 300; i++ {
+		key := []byte(cmn.RandStr(8))
+		tree.Set(key, []byte(cmn.RandStr(8)))
+		keys = append(keys, key)
+	}
+	sortByteSlices(keys)
+	root := tree.WorkingHash()
+
+	ranges := [][2][]byte{
+		{nil, nil},
+		{keys[10], keys[200]},
+		{keys[0], keys[1]},
+		{[]byte("0"), []byte("1")},
+		{keys[299], nil},
+	}
+	for _, r := range ranges {
+		for _, limit := range []int{0, 1, 2, 50} {
+			_, _, proof, err := tree.GetRangeWithProof(r[0], r[1], limit)
+			require.NoError(t, err)
+			bz, err := proof.ToCompact()
+			require.NoError(t, err)
+
+			decoded, err := RangeProofFromCompact(bz)
+			require.NoError(t, err)
+			require.Equal(t, proof.LeftPath, decoded.LeftPath)
+			require.Equal(t, proof.InnerNodes, decoded.InnerNodes)
+			require.Equal(t, proof.Leaves, decoded.Leaves)
+			require.NoError(t, decoded.Verify(root))
+
+			pbBz, err := encodeProof(proof)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(bz), len(pbBz))
+			if len(proof.Leaves) > 10 {
+				// Most right sibling hashes are left out.
+				require.Less(t, len(bz), len(pbBz)*2/3)
+			}
+		}
+	}
+
+	// Random mutations must not verify.
+	_, _, proof, err := tree.GetRangeWithProof(keys[10], keys[20], 0)
+	require.NoError(t, err)
+	bz, err := proof.ToCompact()
+	require.NoError(t, err)
+	for i := 0; i < 1e4; i++ {
+		badBz := cmn.MutateByteSlice(bz)
+		badProof, err := RangeProofFromCompact(badBz)
+		if err != nil {
+			continue
+		}
+		badBz2, err := badProof.ToCompact()
+		if err != nil || bytes.Equal(bz, badBz2) {
+			continue
+		}
+		require.Error(t, badProof.Verify(root), "Proof was still valid after a random mutation:\n%X\n%X", bz, badBz)
+	}
+
+	_, err = RangeProofFromCompact(nil)
+	require.Error(t, err)
+	_, err = RangeProofFromCompact(append(bz, 0x00))
+	require.Error(t, err)
+	_, err = RangeProofFromCompact(bz[:len(bz)-1])
+	require.Error(t, err)
+}
+
+func TestRangePages(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		tree.Set([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i)))
+	}
+	// Keys that cpIncr() would skip.
+	tree.Set([]byte("key50\x00"), []byte("zero"))
+	tree.Set([]byte("key50\x00\x00"), []byte("zero zero"))
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	getPages := func(start, end []byte, limit int) []*RangePage {
+		page, err := tree.GetVersionedRangePageWithProof(start, end, limit, version)
+		require.NoError(t, err)
+		pages := []*RangePage{page}
+		for page.Next != nil {
+			require.Equal(t, version, page.Next.Version)
+			page, err = tree.GetVersionedRangePageWithProof(page.Next.Key, end, limit, page.Next.Version)
+			require.NoError(t, err)
+			pages = append(pages, page)
+		}
+		return pages
+	}
+
+	ranges := [][2][]byte{
+		{nil, nil},
+		{[]byte("key10"), []byte("key20")},
+		{[]byte("key1"), []byte("key2")},
+		{[]byte("key45"), []byte("key55")},
+		{[]byte("key50"), []byte("key50\x00")},
+		{[]byte("key99"), nil},
+		{[]byte("a"), []byte("b")},
+		{[]byte("z"), nil},
+	}
+	for _, r := range ranges {
+		var expectKeys, expectValues [][]byte
+		tree.IterateRange(r[0], r[1], true, func(key, value []byte) bool {
+			expectKeys = append(expectKeys, key)
+			expectValues = append(expectValues, value)
+			return false
+		})
+		for _, limit := range []int{1, 3, 7, 200} {
+			pages := getPages(r[0], r[1], limit)
+			if len(expectKeys) > 0 {
+				require.Len(t, pages, (len(expectKeys)+limit-1)/limit)
+			}
+			// Proofs hold at most the leaf before the page and the one after it.
+			for _, page := range pages {
+				require.LessOrEqual(t, len(page.Proof.Leaves), len(page.Keys)+2)
+			}
+			keys, values, err := VerifyRangePages(root, r[0], r[1], pages)
+			require.NoError(t, err)
+			require.Equal(t, expectKeys, keys)
+			require.Equal(t, expectValues, values)
+		}
+	}
+
+	pages := getPages([]byte("key10"), []byte("key60"), 5)
+	require.True(t, len(pages) > 3)
+	verify := func(pages []*RangePage) error {
+		_, _, err := VerifyRangePages(root, []byte("key10"), []byte("key60"), pages)
+		return err
+	}
+	require.NoError(t, verify(pages))
+	require.Error(t, verify(nil))
+	// Skipping, truncating or reordering pages.
+	require.Error(t, verify(append(pages[:1:1], pages[2:]...)))
+	require.Error(t, verify(pages[:len(pages)-1]))
+	require.Error(t, verify(pages[1:]))
+	// Against another root.
+	_, _, err = VerifyRangePages([]byte("foo"), []byte("key10"), []byte("key60"), pages)
+	require.Error(t, err)
+	// Against a larger range.
+	_, _, err = VerifyRangePages(root, []byte("key10"), nil, pages)
+	require.Error(t, err)
+	// Dropping a key from a page.
+	page := *pages[1]
+	page.Keys, page.Values = page.Keys[1:], page.Values[1:]
+	require.Error(t, verify([]*RangePage{pages[0], &page}))
+	// Moving the continuation token forward.
+	page = *pages[0]
+	page.Next = &PageToken{Key: pages[1].Next.Key, Version: version}
+	require.Error(t, verify(append([]*RangePage{&page}, pages[2:]...)))
+	// Changing a value.
+	page = *pages[0]
+	page.Values = append([][]byte{[]byte("foo")}, page.Values[1:]...)
+	require.Error(t, verify(append([]*RangePage{&page}, pages[1:]...)))
+
+	// Empty trees have no proof.
+	emptyTree, err := getTestTree(0)
+	require.NoError(t, err)
+	page2, err := emptyTree.GetRangePageWithProof(nil, nil, 10)
+	require.NoError(t, err)
+	keys, _, err := VerifyRangePages(emptyTree.WorkingHash(), nil, nil, []*RangePage{page2})
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	_, _, err = VerifyRangePages(root, nil, nil, []*RangePage{page2})
+	require.Error(t, err)
+
+	_, err = tree.GetVersionedRangePageWithProof(nil, nil, 0, version)
+	require.Error(t, err)
+	_, err = tree.GetVersionedRangePageWithProof([]byte("b"), []byte("a"), 1, version)
+	require.Error(t, err)
+	_, err = tree.GetVersionedRangePageWithProof(nil, nil, 1, version+1)
+	require.Error(t, err)
+}
+
+func TestRangeProofKeysExtendingPrevious(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "a/", "a/b", "a/b/c", "a0", "b"} {
+		tree.Set([]byte(key), []byte(key))
+	}
+	root := tree.WorkingHash()
+
+	// The range ends at cpIncr() of keys that other keys in the range extend.
+	for _, c := range []struct {
+		start, end string
+		keys       []string
+	}{
+		{"a/", "a0", []string{"a/", "a/b", "a/b/c"}},
+		{"a/b", "a/c", []string{"a/b", "a/b/c"}},
+		{"a", "b", []string{"a", "a/", "a/b", "a/b/c", "a0"}},
+	} {
+		keys, _, proof, err := tree.GetRangeWithProof([]byte(c.start), []byte(c.end), 0)
+		require.NoError(t, err)
+		actual := []string{}
+		for _, key := range keys {
+			actual = append(actual, string(key))
+		}
+		require.Equal(t, c.keys, actual, "range %q-%q", c.start, c.end)
+		require.NoError(t, proof.Verify(root))
+	}
+}
@@ -6,6 +6,7 @@ package proto
 import (
 	context "context"
 	fmt "fmt"
+	proofpb "github.com/cosmos/iavl/proto/proofpb"
 	proto "github.com/gogo/protobuf/proto"
 	empty "github.com/golang/protobuf/ptypes/empty"
 	_ "google.golang.org/genproto/googleapis/api/annotations"
@@ -449,8 +450,8 @@ func (m *VersionExistsRequest) GetVersion() int64 {
 }
 
 type VerifyRequest struct {
-	RootHash []byte      `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
-	Proof    *RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
+	RootHash []byte              `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
+	Proof    *proofpb.RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
 }
 
 func (m *VerifyRequest) Reset()         { *m = VerifyRequest{} }
@@ -493,7 +494,7 @@ func (m *VerifyRequest) GetRootHash() []byte {
 	return nil
 }
 
-func (m *VerifyRequest) GetProof() *RangeProof {
+func (m *VerifyRequest) GetProof() *proofpb.RangeProof {
 	if m != nil {
 		return m.Proof
 	}
@@ -501,10 +502,10 @@ func (m *VerifyRequest) GetProof() *RangeProof {
 }
 
 type VerifyItemRequest struct {
-	RootHash []byte      `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
-	Proof    *RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
-	Key      []byte      `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
-	Value    []byte      `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	RootHash []byte              `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
+	Proof    *proofpb.RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
+	Key      []byte              `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Value    []byte              `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
 }
 
 func (m *VerifyItemRequest) Reset()         { *m = VerifyItemRequest{} }
@@ -547,7 +548,7 @@ func (m *VerifyItemRequest) GetRootHash() []byte {
 	return nil
 }
 
-func (m *VerifyItemRequest) GetProof() *RangeProof {
+func (m *VerifyItemRequest) GetProof() *proofpb.RangeProof {
 	if m != nil {
 		return m.Proof
 	}
@@ -569,9 +570,9 @@ func (m *VerifyItemRequest) GetValue() []byte {
 }
 
 type VerifyAbsenceRequest struct {
-	RootHash []byte      `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
-	Proof    *RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
-	Key      []byte      `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	RootHash []byte              `protobuf:"bytes,1,opt,name=root_hash,json=rootHash,proto3" json:"root_hash,omitempty"`
+	Proof    *proofpb.RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
+	Key      []byte              `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
 }
 
 func (m *VerifyAbsenceRequest) Reset()         { *m = VerifyAbsenceRequest{} }
@@ -614,7 +615,7 @@ func (m *VerifyAbsenceRequest) GetRootHash() []byte {
 	return nil
 }
 
-func (m *VerifyAbsenceRequest) GetProof() *RangeProof {
+func (m *VerifyAbsenceRequest) GetProof() *proofpb.RangeProof {
 	if m != nil {
 		return m.Proof
 	}
@@ -1265,8 +1266,8 @@ func (m *VersionExistsResponse) GetResult() bool {
 }
 
 type GetWithProofResponse struct {
-	Value []byte      `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
-	Proof *RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
+	Value []byte              `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Proof *proofpb.RangeProof `protobuf:"bytes,2,opt,name=proof,proto3" json:"proof,omitempty"`
 }
 
 func (m *GetWithProofResponse) Reset()         { *m = GetWithProofResponse{} }
@@ -1309,7 +1310,7 @@ func (m *GetWithProofResponse) GetValue() []byte {
 	return nil
 }
 
-func (m *GetWithProofResponse) GetProof() *RangeProof {
+func (m *GetWithProofResponse) GetProof() *proofpb.RangeProof {
 	if m != nil {
 		return m.Proof
 	}
@@ -4982,7 +4983,7 @@ func (m *VerifyRequest) Unmarshal(dAtA []byte) error {
 				return io.ErrUnexpectedEOF
 			}
 			if m.Proof == nil {
-				m.Proof = &RangeProof{}
+				m.Proof = &proofpb.RangeProof{}
 			}
 			if err := m.Proof.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
@@ -5105,7 +5106,7 @@ func (m *VerifyItemRequest) Unmarshal(dAtA []byte) error {
 				return io.ErrUnexpectedEOF
 			}
 			if m.Proof == nil {
-				m.Proof = &RangeProof{}
+				m.Proof = &proofpb.RangeProof{}
 			}
 			if err := m.Proof.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
@@ -5296,7 +5297,7 @@ func (m *VerifyAbsenceRequest) Unmarshal(dAtA []byte) error {
 				return io.ErrUnexpectedEOF
 			}
 			if m.Proof == nil {
-				m.Proof = &RangeProof{}
+				m.Proof = &proofpb.RangeProof{}
 			}
 			if err := m.Proof.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
@@ -6682,7 +6683,7 @@ func (m *GetWithProofResponse) Unmarshal(dAtA []byte) error {
 				return io.ErrUnexpectedEOF
 			}
 			if m.Proof == nil {
-				m.Proof = &RangeProof{}
+				m.Proof = &proofpb.RangeProof{}
 			}
 			if err := m.Proof.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
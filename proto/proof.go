@@ -0,0 +1,15 @@
+package proto
+
+import "github.com/cosmos/iavl/proto/proofpb"
+
+// The proof messages are generated into the proofpb package, which has no gRPC dependencies, so
+// that they can be used without the service definitions. They are aliased here for compatibility.
+
+type (
+	ValueOp        = proofpb.ValueOp
+	AbsenceOp      = proofpb.AbsenceOp
+	RangeProof     = proofpb.RangeProof
+	PathToLeaf     = proofpb.PathToLeaf
+	ProofInnerNode = proofpb.ProofInnerNode
+	ProofLeafNode  = proofpb.ProofLeafNode
+)
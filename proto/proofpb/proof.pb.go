@@ -1,7 +1,7 @@
 // Code generated by protoc-gen-gogo. DO NOT EDIT.
 // source: iavl/proof.proto
 
-package proto
+package proofpb
 
 import (
 	fmt "fmt"
@@ -368,31 +368,32 @@ func init() {
 func init() { proto.RegisterFile("iavl/proof.proto", fileDescriptor_92b2514a05d2a2db) }
 
 var fileDescriptor_92b2514a05d2a2db = []byte{
-	// 373 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x92, 0xb1, 0x4e, 0xe3, 0x40,
-	0x10, 0x86, 0xb3, 0x71, 0xec, 0x5c, 0x26, 0xb9, 0x53, 0x6e, 0x2f, 0x3a, 0x6d, 0x73, 0x3e, 0xcb,
-	0x05, 0xb2, 0x04, 0x0a, 0x4a, 0xd2, 0xd1, 0x41, 0x05, 0x12, 0x82, 0x68, 0x85, 0x28, 0xd2, 0x44,
-	0x1b, 0xb2, 0x89, 0x2d, 0x2c, 0xaf, 0xe5, 0x35, 0x96, 0xa0, 0xe2, 0x11, 0x78, 0x03, 0x5e, 0x87,
-	0x32, 0x25, 0x25, 0x4a, 0x5e, 0x04, 0xed, 0xc4, 0x51, 0x48, 0x01, 0x12, 0x95, 0x67, 0xfe, 0xf9,
-	0x66, 0xfe, 0xf1, 0xd8, 0xd0, 0x8e, 0x44, 0x11, 0x1f, 0xa6, 0x99, 0x52, 0xb3, 0x6e, 0x9a, 0xa9,
-	0x5c, 0xd1, 0x9a, 0x51, 0xfc, 0x1e, 0xd4, 0xaf, 0x45, 0x7c, 0x27, 0x2f, 0x53, 0xba, 0x07, 0x36,
-	0xd6, 0x19, 0xf1, 0x48, 0xd0, 0xec, 0xb7, 0xbb, 0x06, 0xe8, 0x72, 0x91, 0xcc, 0xe5, 0xd0, 0xe8,
-	0x7c, 0x5d, 0xf6, 0x07, 0xd0, 0x38, 0x9e, 0x68, 0x99, 0xdc, 0x7c, 0xa7, 0xe9, 0x99, 0x00, 0x6c,
-	0x55, 0xda, 0x83, 0x46, 0x2c, 0x67, 0xf9, 0x38, 0x15, 0x79, 0xc8, 0x88, 0x67, 0x05, 0xcd, 0x7e,
-	0x67, 0xdd, 0x8a, 0xf5, 0xb3, 0x24, 0x91, 0xd9, 0x85, 0x9a, 0x4a, 0xfe, 0xc3, 0x60, 0x43, 0x91,
-	0x87, 0xb4, 0x07, 0xcd, 0xc8, 0xc8, 0xe3, 0x44, 0x4d, 0xa5, 0x66, 0x55, 0x6c, 0x2a, 0xfd, 0x0c,
-	0x70, 0xa5, 0xce, 0xa5, 0x98, 0x71, 0x88, 0x36, 0xbd, 0x9a, 0xee, 0x83, 0x13, 0x4b, 0x51, 0x48,
-	0xcd, 0x2c, 0xa4, 0xff, 0x7c, 0xb0, 0x30, 0x30, 0x3a, 0x94, 0x88, 0x7f, 0x04, 0xb0, 0x1d, 0x43,
-	0x0f, 0xc0, 0xc1, 0x41, 0xfa, 0xcb, 0xed, 0x4a, 0xc6, 0x7f, 0x24, 0xf0, 0x6b, 0xb7, 0x44, 0xff,
-	0x82, 0x13, 0xca, 0x68, 0x1e, 0xe6, 0x78, 0x99, 0xdf, 0xbc, 0xcc, 0x28, 0x85, 0x9a, 0x8e, 0x1e,
-	0x24, 0xab, 0x7a, 0x24, 0xb0, 0x38, 0xc6, 0x94, 0x41, 0xbd, 0x90, 0x99, 0x8e, 0x54, 0xc2, 0x2c,
-	0x94, 0x37, 0xa9, 0xa1, 0xcd, 0x01, 0x58, 0xcd, 0x23, 0x41, 0x8b, 0x63, 0x4c, 0x3b, 0x60, 0x67,
-	0x38, 0xd8, 0x46, 0x71, 0x9d, 0xf8, 0x23, 0xf8, 0xb9, 0xf3, 0x5e, 0xb4, 0x0d, 0xd6, 0xad, 0xbc,
-	0x47, 0xf7, 0x16, 0x37, 0x21, 0xfd, 0x07, 0x50, 0x98, 0x6f, 0x3d, 0x0e, 0x85, 0x0e, 0x71, 0x81,
-	0x16, 0x6f, 0xa0, 0x72, 0x2a, 0x74, 0xf8, 0xf9, 0x16, 0x27, 0xff, 0x5f, 0x96, 0x2e, 0x59, 0x2c,
-	0x5d, 0xf2, 0xb6, 0x74, 0xc9, 0xd3, 0xca, 0xad, 0x2c, 0x56, 0x6e, 0xe5, 0x75, 0xe5, 0x56, 0x46,
-	0x36, 0xfe, 0x4b, 0x13, 0x07, 0x1f, 0x83, 0xf7, 0x00, 0x00, 0x00, 0xff, 0xff, 0x9e, 0x60, 0x25,
-	0x89, 0x66, 0x02, 0x00, 0x00,
+	// 392 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x92, 0xc1, 0x6e, 0xda, 0x40,
+	0x10, 0x86, 0x59, 0x0c, 0xa6, 0x0c, 0xb4, 0xa2, 0x5b, 0x54, 0xed, 0xa5, 0x96, 0x65, 0x55, 0x95,
+	0xa5, 0x56, 0x46, 0xc0, 0xad, 0x87, 0x4a, 0xed, 0xa9, 0x91, 0xa2, 0x04, 0xad, 0xa2, 0x1c, 0xb8,
+	0xa0, 0x35, 0x2c, 0xac, 0x15, 0xe3, 0xb5, 0xbc, 0xc6, 0x52, 0x72, 0xca, 0x23, 0xe4, 0x0d, 0xf2,
+	0x3a, 0x39, 0x72, 0xcc, 0x31, 0x82, 0x17, 0x89, 0x76, 0x0d, 0x22, 0x1c, 0x12, 0x29, 0xb7, 0x99,
+	0x7f, 0xbe, 0x99, 0x7f, 0xbc, 0x1e, 0xe8, 0x44, 0xac, 0x88, 0x7b, 0x69, 0x26, 0xe5, 0x3c, 0x48,
+	0x33, 0x99, 0x4b, 0x5c, 0xd3, 0x8a, 0xd7, 0x87, 0xc6, 0x25, 0x8b, 0x57, 0xfc, 0x3c, 0xc5, 0x3f,
+	0xa0, 0x6e, 0xea, 0x04, 0xb9, 0xc8, 0x6f, 0x0d, 0x3a, 0x81, 0x06, 0x02, 0xca, 0x92, 0x05, 0x1f,
+	0x69, 0x9d, 0x96, 0x65, 0x6f, 0x08, 0xcd, 0xbf, 0xa1, 0xe2, 0xc9, 0xf4, 0x3d, 0x4d, 0xf7, 0x08,
+	0xe0, 0xa0, 0xe2, 0x3e, 0x34, 0x63, 0x3e, 0xcf, 0x27, 0x29, 0xcb, 0x05, 0x41, 0xae, 0xe5, 0xb7,
+	0x06, 0xdd, 0xb2, 0xd5, 0xd4, 0x4f, 0x92, 0x84, 0x67, 0x67, 0x72, 0xc6, 0xe9, 0x07, 0x8d, 0x8d,
+	0x58, 0x2e, 0x70, 0x1f, 0x5a, 0x91, 0x96, 0x27, 0x89, 0x9c, 0x71, 0x45, 0xaa, 0xae, 0x75, 0xf0,
+	0xd3, 0xc0, 0x85, 0x3c, 0xe5, 0x6c, 0x4e, 0x21, 0xda, 0xf7, 0x2a, 0xfc, 0x13, 0xec, 0x98, 0xb3,
+	0x82, 0x2b, 0x62, 0x19, 0xfa, 0xcb, 0x0b, 0x0b, 0x0d, 0x1b, 0x87, 0x1d, 0xe2, 0xfd, 0x06, 0x38,
+	0x8c, 0xc1, 0xbf, 0xc0, 0x36, 0x83, 0xd4, 0x9b, 0xdb, 0xed, 0x18, 0xef, 0x16, 0xc1, 0xa7, 0xe3,
+	0x12, 0xfe, 0x0a, 0xb6, 0xe0, 0xd1, 0x42, 0xe4, 0xe6, 0x65, 0x3e, 0xd3, 0x5d, 0x86, 0x31, 0xd4,
+	0x54, 0x74, 0xc3, 0x49, 0xd5, 0x45, 0xbe, 0x45, 0x4d, 0x8c, 0x09, 0x34, 0x0a, 0x9e, 0xa9, 0x48,
+	0x26, 0xc4, 0x32, 0xf2, 0x3e, 0xd5, 0xb4, 0x7e, 0x00, 0x52, 0x73, 0x91, 0xdf, 0xa6, 0x26, 0xc6,
+	0x5d, 0xa8, 0x67, 0x66, 0x70, 0xdd, 0x88, 0x65, 0xe2, 0x8d, 0xe1, 0xe3, 0xd1, 0x77, 0xe1, 0x0e,
+	0x58, 0x57, 0xfc, 0xda, 0xb8, 0xb7, 0xa9, 0x0e, 0xf1, 0x37, 0x80, 0x42, 0xff, 0xeb, 0x89, 0x60,
+	0x4a, 0x98, 0x05, 0xda, 0xb4, 0x69, 0x94, 0xff, 0x4c, 0x89, 0xd7, 0xb7, 0xf8, 0xf7, 0xe7, 0x61,
+	0xe3, 0xa0, 0xf5, 0xc6, 0x41, 0x4f, 0x1b, 0x07, 0xdd, 0x6d, 0x9d, 0xca, 0x7a, 0xeb, 0x54, 0x1e,
+	0xb7, 0x4e, 0x65, 0xfc, 0x7d, 0x11, 0xe5, 0x62, 0x15, 0x06, 0x53, 0xb9, 0xec, 0x4d, 0xa5, 0x5a,
+	0x4a, 0xd5, 0xdb, 0x1f, 0x5a, 0x2e, 0xcb, 0x73, 0x4b, 0xc3, 0xd0, 0x36, 0xe9, 0xf0, 0x79, 0x00,
+	0x22, 0xf4, 0x29, 0x40, 0x85, 0x02, 0x00, 0x00,
 }
 
 func (m *ValueOp) Marshal() (dAtA []byte, err error) {
@@ -0,0 +1,71 @@
+package iavl
+
+import "github.com/pkg/errors"
+
+// savepoint is a snapshot of the working tree. The working tree is copy-on-write, so the root
+// remains valid as the tree changes, and the orphans and changed keys added since are found in
+// the tree's logs.
+type savepoint struct {
+	id      int
+	root    *Node
+	orphans int // length of orphansLog
+	changed int // length of changedLog
+}
+
+// Savepoint creates a savepoint of the working tree, and returns its ID. Savepoints may be nested,
+// and are discarded by SaveVersion(), Rollback() and loading a version.
+func (tree *MutableTree) Savepoint() int {
+	tree.nextSavepoint++
+	tree.savepoints = append(tree.savepoints, savepoint{
+		id:      tree.nextSavepoint,
+		root:    tree.root,
+		orphans: len(tree.orphansLog),
+		changed: len(tree.changedLog),
+	})
+	return tree.nextSavepoint
+}
+
+// RollbackToSavepoint discards the changes to the working tree made since the given savepoint,
+// along with any savepoints created after it. The savepoint itself is kept.
+func (tree *MutableTree) RollbackToSavepoint(id int) error {
+	i, err := tree.findSavepoint(id)
+	if err != nil {
+		return err
+	}
+	sp := tree.savepoints[i]
+	for _, hash := range tree.orphansLog[sp.orphans:] {
+		delete(tree.orphans, hash)
+	}
+	for _, key := range tree.changedLog[sp.changed:] {
+		delete(tree.changedKeys, key)
+	}
+	tree.orphansLog = tree.orphansLog[:sp.orphans]
+	tree.changedLog = tree.changedLog[:sp.changed]
+	tree.savepoints = tree.savepoints[:i+1]
+	tree.root = sp.root
+	return nil
+}
+
+// ReleaseSavepoint discards the given savepoint, along with any savepoints created after it,
+// keeping the changes made since.
+func (tree *MutableTree) ReleaseSavepoint(id int) error {
+	i, err := tree.findSavepoint(id)
+	if err != nil {
+		return err
+	}
+	tree.savepoints = tree.savepoints[:i]
+	if i == 0 {
+		tree.orphansLog = nil
+		tree.changedLog = nil
+	}
+	return nil
+}
+
+func (tree *MutableTree) findSavepoint(id int) (int, error) {
+	for i, sp := range tree.savepoints {
+		if sp.id == id {
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("savepoint %v does not exist", id)
+}
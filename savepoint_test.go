@@ -0,0 +1,112 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+func TestSavepoints(t *testing.T) {
+	// Each tree is saved with the same keys, and the reference tree replays only the changes
+	// that were kept.
+	newTree := func() *MutableTree {
+		tree, err := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{KeyHistory: true})
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			tree.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+		}
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+		return tree
+	}
+	tree, expect := newTree(), newTree()
+
+	type op struct {
+		key    []byte
+		value  []byte
+		remove bool
+	}
+	r := rand.New(rand.NewSource(1))
+	randomOps := func(n int) []op {
+		ops := make([]op, 0, n)
+		for i := 0; i < n; i++ {
+			ops = append(ops, op{
+				key:    []byte(fmt.Sprintf("k%03d", r.Intn(150))),
+				value:  []byte(fmt.Sprintf("v%d", r.Int())),
+				remove: r.Intn(3) == 0,
+			})
+		}
+		return ops
+	}
+	apply := func(tree *MutableTree, ops []op) {
+		for _, o := range ops {
+			if o.remove {
+				tree.Remove(o.key)
+			} else {
+				tree.Set(o.key, o.value)
+			}
+		}
+	}
+
+	for round := 0; round < 20; round++ {
+		kept := randomOps(10)
+		apply(tree, kept)
+		outer := tree.Savepoint()
+		apply(tree, randomOps(10))
+
+		inner := tree.Savepoint()
+		innerOps := randomOps(10)
+		apply(tree, innerOps)
+		nested := tree.Savepoint()
+		apply(tree, randomOps(10))
+
+		switch round % 3 {
+		case 0:
+			// Roll back everything, including nested savepoints.
+			require.NoError(t, tree.RollbackToSavepoint(outer))
+			require.Error(t, tree.RollbackToSavepoint(inner))
+			require.NoError(t, tree.ReleaseSavepoint(outer))
+		case 1:
+			// Roll back the nested changes only, keeping the inner ones.
+			require.NoError(t, tree.RollbackToSavepoint(nested))
+			require.NoError(t, tree.ReleaseSavepoint(inner))
+			require.Error(t, tree.ReleaseSavepoint(nested))
+			require.NoError(t, tree.RollbackToSavepoint(outer))
+			apply(tree, innerOps)
+			kept = append(kept, innerOps...)
+			require.NoError(t, tree.ReleaseSavepoint(outer))
+		case 2:
+			// Released changes are kept.
+			ops := randomOps(10)
+			apply(tree, ops)
+			require.NoError(t, tree.RollbackToSavepoint(outer))
+			apply(tree, ops)
+			require.NoError(t, tree.ReleaseSavepoint(outer))
+			kept = append(kept, ops...)
+		}
+		apply(expect, kept)
+
+		require.Equal(t, expect.WorkingHash(), tree.WorkingHash())
+		require.Equal(t, expect.orphans, tree.orphans)
+		require.Equal(t, expect.changedKeys, tree.changedKeys)
+		require.Empty(t, tree.orphansLog)
+
+		if round%5 == 4 {
+			hash, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+			expectHash, _, err := expect.SaveVersion()
+			require.NoError(t, err)
+			require.Equal(t, expectHash, hash)
+		}
+	}
+
+	// Savepoints are discarded by Rollback.
+	id := tree.Savepoint()
+	tree.Rollback()
+	require.Error(t, tree.RollbackToSavepoint(id))
+	require.Error(t, tree.ReleaseSavepoint(id))
+}
@@ -4,4 +4,6 @@ set -eo pipefail
 
 buf generate --path proto/iavl
 
+# The proof messages have their own Go package, without the gRPC service.
+mv ./proto/iavl/proof.pb.go ./proto/proofpb
 mv ./proto/iavl/*.go ./proto
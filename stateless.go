@@ -0,0 +1,114 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// MissingWitnessError is returned by StatelessTree operations that need a node which was pruned
+// from the witness.
+type MissingWitnessError struct {
+	Hash []byte // hash of the missing node
+}
+
+// Error implements error.
+func (e *MissingWitnessError) Error() string {
+	return fmt.Sprintf("node %X is missing from the witness", e.Hash)
+}
+
+// StatelessTree is a MutableTree backed only by the nodes of a Witness, with pruned subtrees kept
+// as bare hashes. It replays changes to the recorded tree version, and computes the new root hash
+// without access to the full database. The same operations that were performed while recording
+// the witness are guaranteed to succeed, and to result in the root hash computed by a full node
+// with SaveVersion. Operations that need a pruned node return a *MissingWitnessError, and leave the
+// tree unchanged.
+//
+// StatelessTree is not safe for concurrent use.
+type StatelessTree struct {
+	tree *MutableTree
+}
+
+// NewStatelessTree creates a StatelessTree from a witness, after verifying it against the given
+// root hash.
+func NewStatelessTree(witness *Witness, root []byte) (*StatelessTree, error) {
+	if err := witness.Verify(root); err != nil {
+		return nil, errors.Wrap(err, "invalid witness")
+	}
+	tree, err := NewMutableTree(db.NewMemDB(), 0)
+	if err != nil {
+		return nil, err
+	}
+	tree.ndb.stateless = true
+	for hash, node := range witness.nodes {
+		var buf bytes.Buffer
+		buf.Grow(node.encodedSize())
+		if err = node.writeBytes(&buf); err != nil {
+			return nil, err
+		}
+		if err = tree.ndb.batch.Set(tree.ndb.nodeKey([]byte(hash)), buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err = tree.ndb.Commit(); err != nil {
+		return nil, err
+	}
+	if len(witness.nodes) > 0 {
+		tree.ImmutableTree.root = tree.ndb.GetNode(root)
+	}
+	tree.ImmutableTree.version = witness.version
+	tree.lastSaved = tree.ImmutableTree.clone()
+	return &StatelessTree{tree: tree}, nil
+}
+
+// Version returns the version of the tree the witness was recorded from.
+func (t *StatelessTree) Version() int64 {
+	return t.tree.version
+}
+
+// WorkingHash returns the root hash of the tree, including the changes made so far.
+func (t *StatelessTree) WorkingHash() []byte {
+	return t.tree.WorkingHash()
+}
+
+// Get returns the value of the specified key if it exists, or nil otherwise.
+func (t *StatelessTree) Get(key []byte) (value []byte, err error) {
+	defer recoverMissingWitness(&err)
+	_, value = t.tree.Get(key)
+	return value, nil
+}
+
+// Has returns whether or not a key exists.
+func (t *StatelessTree) Has(key []byte) (has bool, err error) {
+	defer recoverMissingWitness(&err)
+	return t.tree.Has(key), nil
+}
+
+// Set sets a key in the tree. Returns true if an existing key was updated.
+func (t *StatelessTree) Set(key, value []byte) (updated bool, err error) {
+	defer recoverMissingWitness(&err)
+	return t.tree.Set(key, value), nil
+}
+
+// Remove removes a key from the tree, and returns its value if it was removed.
+func (t *StatelessTree) Remove(key []byte) (value []byte, removed bool, err error) {
+	defer recoverMissingWitness(&err)
+	value, removed = t.tree.Remove(key)
+	return value, removed, nil
+}
+
+// recoverMissingWitness recovers from a panic on a node missing from a witness, returning it as
+// an error. MutableTree only replaces its root once a change is complete, so the tree is left
+// unchanged.
+func recoverMissingWitness(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*MissingWitnessError)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
@@ -0,0 +1,117 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatelessTree(t *testing.T) {
+	tree := setupWitnessTree(t)
+	root := tree.Hash()
+	version := tree.Version()
+
+	// Record a block of random changes on a full tree.
+	type op struct {
+		key, value []byte // remove if value is nil
+	}
+	r := rand.New(rand.NewSource(1))
+	ops := make([]op, 0, 200)
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%04d", r.Intn(1200)))
+		var value []byte
+		if r.Intn(3) > 0 {
+			value = []byte(fmt.Sprintf("new%d", i))
+		}
+		ops = append(ops, op{key, value})
+	}
+	require.NoError(t, tree.StartRecording())
+	for _, o := range ops {
+		if o.value != nil {
+			tree.Set(o.key, o.value)
+		} else {
+			tree.Remove(o.key)
+		}
+	}
+	_, value := tree.Get([]byte("key0042"))
+	witness, err := tree.StopRecording()
+	require.NoError(t, err)
+	newRoot, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	bz, err := witness.Marshal()
+	require.NoError(t, err)
+	witness, err = UnmarshalWitness(bz)
+	require.NoError(t, err)
+
+	// Replay the changes on a stateless tree.
+	_, err = NewStatelessTree(witness, newRoot)
+	require.Error(t, err)
+	stateless, err := NewStatelessTree(witness, root)
+	require.NoError(t, err)
+	require.Equal(t, version, stateless.Version())
+	require.Equal(t, root, stateless.WorkingHash())
+
+	for _, o := range ops {
+		if o.value != nil {
+			_, err = stateless.Set(o.key, o.value)
+		} else {
+			_, _, err = stateless.Remove(o.key)
+		}
+		require.NoError(t, err)
+	}
+	statelessValue, err := stateless.Get([]byte("key0042"))
+	require.NoError(t, err)
+	require.Equal(t, value, statelessValue)
+	require.Equal(t, newRoot, stateless.WorkingHash())
+
+	// Operations on pruned subtrees fail, and leave the tree unchanged.
+	var prunedKey []byte
+	for i := 0; i < 1000 && prunedKey == nil; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if _, err = stateless.Has(key); err != nil {
+			prunedKey = key
+		}
+	}
+	require.NotNil(t, prunedKey, "expected a pruned key")
+	var missing *MissingWitnessError
+	require.True(t, errors.As(err, &missing), "%v", err)
+	require.NotEmpty(t, missing.Hash)
+
+	_, err = stateless.Get(prunedKey)
+	require.True(t, errors.As(err, &missing), "%v", err)
+	_, err = stateless.Set(prunedKey, []byte("foo"))
+	require.True(t, errors.As(err, &missing), "%v", err)
+	_, _, err = stateless.Remove(prunedKey)
+	require.True(t, errors.As(err, &missing), "%v", err)
+	require.Equal(t, newRoot, stateless.WorkingHash())
+}
+
+func TestStatelessTree_Empty(t *testing.T) {
+	tree := setupWitnessTree(t)
+	for i := 0; i < 1000; i++ {
+		tree.Remove([]byte(fmt.Sprintf("key%04d", i)))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	require.NoError(t, tree.StartRecording())
+	tree.Set([]byte("a"), []byte("1"))
+	tree.Set([]byte("b"), []byte("2"))
+	witness, err := tree.StopRecording()
+	require.NoError(t, err)
+	newRoot, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	stateless, err := NewStatelessTree(witness, root)
+	require.NoError(t, err)
+	_, err = stateless.Set([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	_, err = stateless.Set([]byte("b"), []byte("2"))
+	require.NoError(t, err)
+	require.Equal(t, newRoot, stateless.WorkingHash())
+}
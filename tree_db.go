@@ -0,0 +1,368 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ErrReadOnlyDB is returned when writing to a TreeDB created by NewReadOnlyTreeDB().
+var ErrReadOnlyDB = errors.New("database is read-only")
+
+// These errors have the same messages as the ones returned by tm-db backends.
+var (
+	errKeyEmpty    = errors.New("key cannot be empty")
+	errValueNil    = errors.New("value cannot be nil")
+	errBatchClosed = errors.New("batch has been written or closed")
+)
+
+// TreeDB implements the tm-db DB interface on top of a tree, so that it can be used wherever a
+// dbm.DB is expected, e.g. to commit to the data with the root hash of the tree. Reads and writes
+// use the working tree of a MutableTree, and changes only become persistent when a version is
+// saved, e.g. when writing a batch if saveOnWrite is set. Like other tm-db backends, it is safe
+// for concurrent use, and iterators hold a read lock until they are closed.
+type TreeDB struct {
+	mtx         sync.RWMutex
+	tree        *MutableTree   // nil if read-only
+	immutable   *ImmutableTree // the tree of a read-only TreeDB
+	saveOnWrite bool
+}
+
+var _ dbm.DB = (*TreeDB)(nil)
+
+// NewTreeDB creates a TreeDB on the working tree of a MutableTree. If saveOnWrite is true, a new
+// version is saved every time a batch is written. The tree must not be used directly while the
+// TreeDB is in use, other than to save versions.
+func NewTreeDB(tree *MutableTree, saveOnWrite bool) *TreeDB {
+	return &TreeDB{tree: tree, saveOnWrite: saveOnWrite}
+}
+
+// NewReadOnlyTreeDB creates a read-only TreeDB on an ImmutableTree, e.g. a saved version. Writes
+// return ErrReadOnlyDB.
+func NewReadOnlyTreeDB(tree *ImmutableTree) *TreeDB {
+	return &TreeDB{immutable: tree}
+}
+
+// snapshot returns the tree to read from.
+func (db *TreeDB) snapshot() *ImmutableTree {
+	if db.tree == nil {
+		return db.immutable
+	}
+	return db.tree.ImmutableTree
+}
+
+// Get implements dbm.DB.
+func (db *TreeDB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	_, value := db.snapshot().Get(key)
+	return value, nil
+}
+
+// Has implements dbm.DB.
+func (db *TreeDB) Has(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, errKeyEmpty
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.snapshot().Has(key), nil
+}
+
+// Set implements dbm.DB. It sets the key in the working tree.
+func (db *TreeDB) Set(key []byte, value []byte) error {
+	if err := db.checkSet(key, value); err != nil {
+		return err
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.tree.Set(key, value)
+	return nil
+}
+
+// SetSync implements dbm.DB. It is the same as Set(), since changes are only flushed to storage
+// when a version is saved.
+func (db *TreeDB) SetSync(key []byte, value []byte) error {
+	return db.Set(key, value)
+}
+
+// Delete implements dbm.DB. It removes the key from the working tree.
+func (db *TreeDB) Delete(key []byte) error {
+	if err := db.checkDelete(key); err != nil {
+		return err
+	}
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.tree.Remove(key)
+	return nil
+}
+
+// DeleteSync implements dbm.DB. It is the same as Delete(), since changes are only flushed to
+// storage when a version is saved.
+func (db *TreeDB) DeleteSync(key []byte) error {
+	return db.Delete(key)
+}
+
+func (db *TreeDB) checkSet(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	if db.tree == nil {
+		return ErrReadOnlyDB
+	}
+	return nil
+}
+
+func (db *TreeDB) checkDelete(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if db.tree == nil {
+		return ErrReadOnlyDB
+	}
+	return nil
+}
+
+// Iterator implements dbm.DB.
+func (db *TreeDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	db.mtx.RLock()
+	return newTreeDBIterator(db.snapshot(), start, end, true, db.mtx.RUnlock), nil
+}
+
+// ReverseIterator implements dbm.DB.
+func (db *TreeDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	db.mtx.RLock()
+	return newTreeDBIterator(db.snapshot(), start, end, false, db.mtx.RUnlock), nil
+}
+
+// Close implements dbm.DB. It does not close the tree or its database.
+func (db *TreeDB) Close() error {
+	return nil
+}
+
+// NewBatch implements dbm.DB. Batches are applied to the working tree when written.
+func (db *TreeDB) NewBatch() dbm.Batch {
+	return &treeDBBatch{db: db}
+}
+
+// Print implements dbm.DB.
+func (db *TreeDB) Print() error {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	db.snapshot().Iterate(func(key, value []byte) bool {
+		fmt.Printf("[%X]:\t[%X]\n", key, value)
+		return false
+	})
+	return nil
+}
+
+// Stats implements dbm.DB.
+func (db *TreeDB) Stats() map[string]string {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	tree := db.snapshot()
+	return map[string]string{
+		"database.type":    "iavl",
+		"database.size":    fmt.Sprintf("%d", tree.Size()),
+		"database.version": fmt.Sprintf("%d", tree.Version()),
+	}
+}
+
+// treeDBBatch is a batch of changes to a TreeDB, applied in order when written.
+type treeDBBatch struct {
+	db     *TreeDB
+	ops    []Mutation // nil values remove keys
+	closed bool
+}
+
+// Set implements dbm.Batch.
+func (b *treeDBBatch) Set(key, value []byte) error {
+	if err := b.db.checkSet(key, value); err != nil {
+		return err
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, Mutation{Key: key, Value: value})
+	return nil
+}
+
+// Delete implements dbm.Batch.
+func (b *treeDBBatch) Delete(key []byte) error {
+	if err := b.db.checkDelete(key); err != nil {
+		return err
+	}
+	if b.closed {
+		return errBatchClosed
+	}
+	b.ops = append(b.ops, Mutation{Key: key})
+	return nil
+}
+
+// Write implements dbm.Batch. It applies the changes to the working tree, and saves a new version
+// if the TreeDB was created with saveOnWrite. The batch is closed once the changes are applied, so
+// if saving fails, the changes remain applied to the working tree, but no version is saved.
+func (b *treeDBBatch) Write() error {
+	if b.closed {
+		return errBatchClosed
+	}
+	if b.db.tree == nil {
+		return ErrReadOnlyDB
+	}
+	b.db.mtx.Lock()
+	defer b.db.mtx.Unlock()
+	if err := b.db.tree.CheckAndMutate(nil, b.ops); err != nil {
+		return err
+	}
+	b.ops = nil
+	b.closed = true
+	if b.db.saveOnWrite {
+		if _, _, err := b.db.tree.SaveVersion(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSync implements dbm.Batch. It is the same as Write().
+func (b *treeDBBatch) WriteSync() error {
+	return b.Write()
+}
+
+// Close implements dbm.Batch.
+func (b *treeDBBatch) Close() error {
+	b.ops = nil
+	b.closed = true
+	return nil
+}
+
+// treeDBIterator iterates over a key range of a tree, keeping a stack of the subtrees left to
+// visit, in order. Subtrees outside of the range are never pushed.
+type treeDBIterator struct {
+	tree       *ImmutableTree
+	start, end []byte
+	ascending  bool
+	stack      []*Node
+	leaf       *Node // the current leaf, or nil once the iterator is invalid
+	release    func()
+}
+
+var _ dbm.Iterator = (*treeDBIterator)(nil)
+
+func newTreeDBIterator(tree *ImmutableTree, start, end []byte, ascending bool, release func()) *treeDBIterator {
+	itr := &treeDBIterator{
+		tree:      tree,
+		start:     start,
+		end:       end,
+		ascending: ascending,
+		release:   release,
+	}
+	empty := start != nil && end != nil && bytes.Compare(start, end) >= 0
+	if tree.root != nil && !empty {
+		itr.stack = append(itr.stack, tree.root)
+	}
+	itr.advance()
+	return itr
+}
+
+// advance moves to the next leaf in range, if any.
+func (itr *treeDBIterator) advance() {
+	itr.leaf = nil
+	for len(itr.stack) > 0 {
+		node := itr.stack[len(itr.stack)-1]
+		itr.stack = itr.stack[:len(itr.stack)-1]
+		if node.isLeaf() {
+			if (itr.start == nil || bytes.Compare(node.key, itr.start) >= 0) &&
+				(itr.end == nil || bytes.Compare(node.key, itr.end) < 0) {
+				itr.leaf = node
+				return
+			}
+			continue
+		}
+		// Keys in the left subtree are less than node.key, and the others are greater or equal.
+		hasLeft := itr.start == nil || bytes.Compare(itr.start, node.key) < 0
+		hasRight := itr.end == nil || bytes.Compare(node.key, itr.end) < 0
+		if itr.ascending {
+			if hasRight {
+				itr.stack = append(itr.stack, node.getRightNode(itr.tree))
+			}
+			if hasLeft {
+				itr.stack = append(itr.stack, node.getLeftNode(itr.tree))
+			}
+		} else {
+			if hasLeft {
+				itr.stack = append(itr.stack, node.getLeftNode(itr.tree))
+			}
+			if hasRight {
+				itr.stack = append(itr.stack, node.getRightNode(itr.tree))
+			}
+		}
+	}
+}
+
+// Domain implements dbm.Iterator.
+func (itr *treeDBIterator) Domain() ([]byte, []byte) {
+	return itr.start, itr.end
+}
+
+// Valid implements dbm.Iterator.
+func (itr *treeDBIterator) Valid() bool {
+	return itr.leaf != nil
+}
+
+// Next implements dbm.Iterator.
+func (itr *treeDBIterator) Next() {
+	itr.assertValid()
+	itr.advance()
+}
+
+// Key implements dbm.Iterator.
+func (itr *treeDBIterator) Key() []byte {
+	itr.assertValid()
+	return itr.leaf.key
+}
+
+// Value implements dbm.Iterator.
+func (itr *treeDBIterator) Value() []byte {
+	itr.assertValid()
+	return itr.leaf.value
+}
+
+// Error implements dbm.Iterator.
+func (itr *treeDBIterator) Error() error {
+	return nil
+}
+
+// Close implements dbm.Iterator. It releases the read lock on the TreeDB, and is safe to call
+// multiple times.
+func (itr *treeDBIterator) Close() error {
+	if itr.release != nil {
+		itr.release()
+		itr.release = nil
+	}
+	itr.stack = nil
+	itr.leaf = nil
+	return nil
+}
+
+func (itr *treeDBIterator) assertValid() {
+	if itr.leaf == nil {
+		panic("iterator is invalid")
+	}
+}
@@ -0,0 +1,258 @@
+package iavl
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+// The tests below follow the conformance tests of tm-db backends.
+
+func TestTreeDB_GetSetDelete(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	treeDB := NewTreeDB(tree, false)
+
+	value, err := treeDB.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	ok, err := treeDB.Has([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, treeDB.Set([]byte("a"), []byte{0x01}))
+	require.NoError(t, treeDB.SetSync([]byte("b"), []byte{0x02}))
+	ok, err = treeDB.Has([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	value, err = treeDB.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02}, value)
+
+	require.NoError(t, treeDB.Delete([]byte("x")))
+	require.NoError(t, treeDB.DeleteSync([]byte("x")))
+	require.NoError(t, treeDB.Delete([]byte("a")))
+	require.NoError(t, treeDB.DeleteSync([]byte("b")))
+	value, err = treeDB.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+	require.True(t, tree.IsEmpty())
+
+	for _, key := range [][]byte{nil, {}} {
+		_, err = treeDB.Get(key)
+		require.Equal(t, errKeyEmpty, err)
+		_, err = treeDB.Has(key)
+		require.Equal(t, errKeyEmpty, err)
+		require.Equal(t, errKeyEmpty, treeDB.Set(key, []byte{0x01}))
+		require.Equal(t, errKeyEmpty, treeDB.SetSync(key, []byte{0x01}))
+		require.Equal(t, errKeyEmpty, treeDB.Delete(key))
+		require.Equal(t, errKeyEmpty, treeDB.DeleteSync(key))
+	}
+	require.Equal(t, errValueNil, treeDB.Set([]byte("x"), nil))
+	require.Equal(t, errValueNil, treeDB.SetSync([]byte("x"), nil))
+
+	// Empty values are fine, also once saved.
+	require.NoError(t, treeDB.Set([]byte("x"), []byte{}))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	value, err = NewReadOnlyTreeDB(tree.ImmutableTree).Get([]byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, value)
+}
+
+func TestTreeDB_Iterator(t *testing.T) {
+	key := func(i int64) []byte {
+		bz := make([]byte, 8)
+		binary.BigEndian.PutUint64(bz, uint64(i))
+		return bz
+	}
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	treeDB := NewTreeDB(tree, false)
+
+	// requireKeys checks the keys of an iterator, and closes it.
+	requireKeys := func(itr db.Iterator, err error, expect []int64, msg string) {
+		require.NoError(t, err)
+		var keys []int64
+		for ; itr.Valid(); itr.Next() {
+			keys = append(keys, int64(binary.BigEndian.Uint64(itr.Key())))
+		}
+		require.NoError(t, itr.Error())
+		require.Panics(t, itr.Next)
+		require.Panics(t, func() { itr.Key() })
+		require.NoError(t, itr.Close())
+		require.Equal(t, expect, keys, msg)
+	}
+	itr, err := treeDB.Iterator(nil, nil)
+	requireKeys(itr, err, nil, "empty tree")
+	itr, err = treeDB.ReverseIterator(nil, nil)
+	requireKeys(itr, err, nil, "empty tree reverse")
+
+	for i := int64(0); i < 10; i++ {
+		if i != 6 {
+			require.NoError(t, treeDB.Set(key(i), []byte{}))
+		}
+	}
+	for _, bounds := range [][2][]byte{{{}, nil}, {nil, {}}} {
+		_, err = treeDB.Iterator(bounds[0], bounds[1])
+		require.Equal(t, errKeyEmpty, err)
+		_, err = treeDB.ReverseIterator(bounds[0], bounds[1])
+		require.Equal(t, errKeyEmpty, err)
+	}
+
+	bound := func(i int64) []byte {
+		if i < 0 {
+			return nil
+		}
+		return key(i)
+	}
+	// Bounds of -1 are nil.
+	for _, c := range []struct {
+		start, end int64
+		ascending  bool
+		expect     []int64
+	}{
+		{-1, -1, true, []int64{0, 1, 2, 3, 4, 5, 7, 8, 9}},
+		{-1, -1, false, []int64{9, 8, 7, 5, 4, 3, 2, 1, 0}},
+		{-1, 0, true, nil},
+		{10, -1, false, nil},
+		{0, -1, true, []int64{0, 1, 2, 3, 4, 5, 7, 8, 9}},
+		{1, -1, true, []int64{1, 2, 3, 4, 5, 7, 8, 9}},
+		{-1, 10, false, []int64{9, 8, 7, 5, 4, 3, 2, 1, 0}},
+		{-1, 9, false, []int64{8, 7, 5, 4, 3, 2, 1, 0}},
+		{-1, 8, false, []int64{7, 5, 4, 3, 2, 1, 0}},
+		{5, 6, true, []int64{5}},
+		{5, 7, true, []int64{5}},
+		{5, 8, true, []int64{5, 7}},
+		{6, 7, true, nil},
+		{6, 8, true, []int64{7}},
+		{7, 8, true, []int64{7}},
+		{4, 5, false, []int64{4}},
+		{4, 6, false, []int64{5, 4}},
+		{4, 7, false, []int64{5, 4}},
+		{5, 6, false, []int64{5}},
+		{5, 7, false, []int64{5}},
+		{6, 7, false, nil},
+		{6, -1, false, []int64{9, 8, 7}},
+		{5, -1, false, []int64{9, 8, 7, 5}},
+		{8, 9, false, []int64{8}},
+		{2, 4, false, []int64{3, 2}},
+		{4, 2, false, nil},
+		{4, 2, true, nil},
+	} {
+		if c.ascending {
+			itr, err = treeDB.Iterator(bound(c.start), bound(c.end))
+		} else {
+			itr, err = treeDB.ReverseIterator(bound(c.start), bound(c.end))
+		}
+		start, end := itr.Domain()
+		require.Equal(t, bound(c.start), start)
+		require.Equal(t, bound(c.end), end)
+		requireKeys(itr, err, c.expect, "")
+	}
+
+	// Iterators over a saved version load nodes from the database.
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	saved, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+	itr, err = NewReadOnlyTreeDB(saved).ReverseIterator(key(2), key(8))
+	requireKeys(itr, err, []int64{7, 5, 4, 3, 2}, "saved version")
+}
+
+func TestTreeDB_Batch(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	treeDB := NewTreeDB(tree, true)
+
+	// requireContents checks the contents of the working tree.
+	requireContents := func(expect map[string][]byte) {
+		actual := map[string][]byte{}
+		tree.Iterate(func(key, value []byte) bool {
+			actual[string(key)] = value
+			return false
+		})
+		require.Equal(t, expect, actual)
+	}
+
+	batch := treeDB.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}))
+	require.NoError(t, batch.Set([]byte("b"), []byte{2}))
+	require.NoError(t, batch.Set([]byte("c"), []byte{3}))
+	requireContents(map[string][]byte{})
+
+	// Writing the batch saves a version.
+	require.NoError(t, batch.Write())
+	requireContents(map[string][]byte{"a": {1}, "b": {2}, "c": {3}})
+	require.EqualValues(t, 1, tree.Version())
+	require.Error(t, batch.Set([]byte("a"), []byte{9}))
+	require.Error(t, batch.Delete([]byte("a")))
+	require.Error(t, batch.Write())
+	require.Error(t, batch.WriteSync())
+	require.NoError(t, batch.Close())
+
+	// Changes are applied in order.
+	batch = treeDB.NewBatch()
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}))
+	require.NoError(t, batch.Set([]byte("b"), []byte{1}))
+	require.NoError(t, batch.Set([]byte("b"), []byte{2}))
+	require.NoError(t, batch.Set([]byte("c"), []byte{3}))
+	require.NoError(t, batch.Delete([]byte("c")))
+	require.NoError(t, batch.WriteSync())
+	require.NoError(t, batch.Close())
+	requireContents(map[string][]byte{"a": {1}, "b": {2}})
+	require.EqualValues(t, 2, tree.Version())
+
+	batch = treeDB.NewBatch()
+	require.Equal(t, errKeyEmpty, batch.Set([]byte{}, []byte{0x01}))
+	require.Equal(t, errKeyEmpty, batch.Set(nil, []byte{0x01}))
+	require.Equal(t, errValueNil, batch.Set([]byte("a"), nil))
+	require.Equal(t, errKeyEmpty, batch.Delete([]byte{}))
+	require.Equal(t, errKeyEmpty, batch.Delete(nil))
+	require.NoError(t, batch.Close())
+	require.NoError(t, batch.Close())
+	require.Error(t, batch.Set([]byte("a"), []byte{9}))
+	require.Error(t, batch.Write())
+
+	batch = treeDB.NewBatch()
+	require.NoError(t, batch.Write())
+	requireContents(map[string][]byte{"a": {1}, "b": {2}})
+
+	// Read-only databases reject all writes.
+	readOnly := NewReadOnlyTreeDB(tree.ImmutableTree)
+	require.Equal(t, ErrReadOnlyDB, readOnly.Set([]byte("a"), []byte{1}))
+	require.Equal(t, ErrReadOnlyDB, readOnly.Delete([]byte("a")))
+	batch = readOnly.NewBatch()
+	require.Equal(t, ErrReadOnlyDB, batch.Set([]byte("a"), []byte{1}))
+	require.Equal(t, ErrReadOnlyDB, batch.Write())
+	value, err := readOnly.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, value)
+}
+
+func TestTreeDB_BatchSaveFailure(t *testing.T) {
+	mdb := &failingWriteDB{DB: db.NewMemDB()}
+	tree, err := NewMutableTree(mdb, 0)
+	require.NoError(t, err)
+	treeDB := NewTreeDB(tree, true)
+
+	batch := treeDB.NewBatch()
+	require.NoError(t, batch.Set([]byte("b"), []byte{2}))
+	require.NoError(t, batch.Write())
+
+	batch = treeDB.NewBatch()
+	require.NoError(t, batch.Set([]byte("a"), []byte{1}))
+	require.NoError(t, batch.Delete([]byte("b")))
+
+	// A failed save leaves the changes applied to the working tree, and the batch closed.
+	mdb.fail = true
+	require.Error(t, batch.Write())
+	_, value := tree.Get([]byte("a"))
+	require.Equal(t, []byte{1}, value)
+	require.False(t, tree.Has([]byte("b")))
+	require.EqualValues(t, 1, tree.Version())
+	require.Equal(t, errBatchClosed, batch.Write())
+}
@@ -0,0 +1,201 @@
+package typed
+
+import (
+	"encoding/binary"
+
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// Codec encodes and decodes values of type T. Codecs used for keys must preserve order, i.e. the
+// encodings of two keys must compare with bytes.Compare in the same order as the keys themselves.
+type Codec[T any] interface {
+	Encode(value T) []byte
+	Decode(bz []byte) (T, error)
+}
+
+var (
+	// Uint64 encodes uint64 values as 8 big-endian bytes, preserving order.
+	Uint64 Codec[uint64] = uint64Codec{}
+	// Int64 encodes int64 values as 8 big-endian bytes with the sign bit flipped, preserving
+	// order.
+	Int64 Codec[int64] = int64Codec{}
+	// Uint32 encodes uint32 values as 4 big-endian bytes, preserving order.
+	Uint32 Codec[uint32] = uint32Codec{}
+	// Int32 encodes int32 values as 4 big-endian bytes with the sign bit flipped, preserving
+	// order.
+	Int32 Codec[int32] = int32Codec{}
+	// String encodes strings as their bytes, preserving order.
+	String Codec[string] = stringCodec{}
+	// Bytes encodes byte slices as themselves, preserving order. Decoded slices are copies.
+	Bytes Codec[[]byte] = bytesCodec{}
+)
+
+type uint64Codec struct{}
+
+func (uint64Codec) Encode(value uint64) []byte {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, value)
+	return bz
+}
+
+func (uint64Codec) Decode(bz []byte) (uint64, error) {
+	if len(bz) != 8 {
+		return 0, errors.Errorf("expected 8 bytes for uint64, got %v", len(bz))
+	}
+	return binary.BigEndian.Uint64(bz), nil
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Encode(value int64) []byte {
+	return Uint64.Encode(uint64(value) ^ (1 << 63))
+}
+
+func (int64Codec) Decode(bz []byte) (int64, error) {
+	value, err := Uint64.Decode(bz)
+	return int64(value ^ (1 << 63)), err
+}
+
+type uint32Codec struct{}
+
+func (uint32Codec) Encode(value uint32) []byte {
+	bz := make([]byte, 4)
+	binary.BigEndian.PutUint32(bz, value)
+	return bz
+}
+
+func (uint32Codec) Decode(bz []byte) (uint32, error) {
+	if len(bz) != 4 {
+		return 0, errors.Errorf("expected 4 bytes for uint32, got %v", len(bz))
+	}
+	return binary.BigEndian.Uint32(bz), nil
+}
+
+type int32Codec struct{}
+
+func (int32Codec) Encode(value int32) []byte {
+	return Uint32.Encode(uint32(value) ^ (1 << 31))
+}
+
+func (int32Codec) Decode(bz []byte) (int32, error) {
+	value, err := Uint32.Decode(bz)
+	return int32(value ^ (1 << 31)), err
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(value string) []byte {
+	return []byte(value)
+}
+
+func (stringCodec) Decode(bz []byte) (string, error) {
+	return string(bz), nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(value []byte) []byte {
+	return value
+}
+
+func (bytesCodec) Decode(bz []byte) ([]byte, error) {
+	return append([]byte{}, bz...), nil
+}
+
+// Pair is a composite key of two components. Keys with more components can nest pairs, e.g.
+// Pair[A, Pair[B, C]].
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// PairOf returns a codec for pairs, ordered by their first and then their second component. The
+// encoding of the first component is escaped and terminated, so that it is never a prefix of
+// another one: 0x00 bytes are encoded as 0x00 0xFF, followed by a 0x00 0x01 terminator. Since the
+// terminator sorts before any escaped byte, this preserves order when both codecs do.
+func PairOf[A, B any](first Codec[A], second Codec[B]) Codec[Pair[A, B]] {
+	return pairCodec[A, B]{first: first, second: second}
+}
+
+type pairCodec[A, B any] struct {
+	first  Codec[A]
+	second Codec[B]
+}
+
+func (c pairCodec[A, B]) Encode(value Pair[A, B]) []byte {
+	first, second := c.first.Encode(value.First), c.second.Encode(value.Second)
+	bz := make([]byte, 0, len(first)+len(second)+2)
+	for _, b := range first {
+		if b == 0x00 {
+			bz = append(bz, 0x00, 0xff)
+		} else {
+			bz = append(bz, b)
+		}
+	}
+	bz = append(bz, 0x00, 0x01)
+	return append(bz, second...)
+}
+
+func (c pairCodec[A, B]) Decode(bz []byte) (Pair[A, B], error) {
+	var pair Pair[A, B]
+	first := make([]byte, 0, len(bz))
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(bz)-1:
+			return pair, errors.New("missing terminator of first pair component")
+		case bz[i] != 0x00:
+			first = append(first, bz[i])
+			continue
+		case bz[i+1] == 0xff:
+			first = append(first, 0x00)
+			i++
+			continue
+		case bz[i+1] != 0x01:
+			return pair, errors.Errorf("invalid escape sequence 0x00 0x%02x in first pair component",
+				bz[i+1])
+		}
+
+		var err error
+		if pair.First, err = c.first.Decode(first); err != nil {
+			return pair, errors.Wrap(err, "decoding first pair component")
+		}
+		if pair.Second, err = c.second.Decode(bz[i+2:]); err != nil {
+			return pair, errors.Wrap(err, "decoding second pair component")
+		}
+		return pair, nil
+	}
+}
+
+// Proto returns a codec for protobuf messages, which is only suitable for values since the
+// encoding does not preserve order. newMessage must return a new empty message to decode into.
+func Proto[T proto.Message](newMessage func() T) Codec[T] {
+	return protoCodec[T]{newMessage: newMessage}
+}
+
+type protoCodec[T proto.Message] struct {
+	newMessage func() T
+}
+
+func (c protoCodec[T]) Encode(value T) []byte {
+	bz, err := proto.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
+func (c protoCodec[T]) Decode(bz []byte) (T, error) {
+	msg := c.newMessage()
+	return msg, proto.Unmarshal(bz, msg)
+}
+
+// encode encodes a value, returning an empty rather than a nil slice, since nil values and bounds
+// have special meanings in IAVL.
+func encode[T any](codec Codec[T], value T) []byte {
+	bz := codec.Encode(value)
+	if bz == nil {
+		bz = []byte{}
+	}
+	return bz
+}
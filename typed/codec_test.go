@@ -0,0 +1,81 @@
+package typed
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireCodec checks that a codec round-trips the given values, and preserves their order.
+func requireCodec[T any](t *testing.T, codec Codec[T], values []T, less func(a, b T) bool) {
+	for _, value := range values {
+		decoded, err := codec.Decode(codec.Encode(value))
+		require.NoError(t, err)
+		require.Equal(t, value, decoded)
+	}
+	for _, a := range values {
+		for _, b := range values {
+			cmp := bytes.Compare(codec.Encode(a), codec.Encode(b))
+			require.Equal(t, less(a, b), cmp < 0, "%v < %v", a, b)
+		}
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	int64s := []int64{math.MinInt64, -1 << 40, -1, 0, 1, 255, 256, 1 << 40, math.MaxInt64}
+	uint64s := []uint64{0, 1, 255, 256, 1 << 40, math.MaxUint64}
+	int32s := []int32{math.MinInt32, -1, 0, 1, 256, math.MaxInt32}
+	uint32s := []uint32{0, 1, 256, math.MaxUint32}
+	for i := 0; i < 20; i++ {
+		int64s = append(int64s, r.Int63()-r.Int63())
+		uint64s = append(uint64s, r.Uint64())
+		int32s = append(int32s, r.Int31()-r.Int31())
+		uint32s = append(uint32s, r.Uint32())
+	}
+	requireCodec(t, Int64, int64s, func(a, b int64) bool { return a < b })
+	requireCodec(t, Uint64, uint64s, func(a, b uint64) bool { return a < b })
+	requireCodec(t, Int32, int32s, func(a, b int32) bool { return a < b })
+	requireCodec(t, Uint32, uint32s, func(a, b uint32) bool { return a < b })
+
+	strings := []string{"", "a", "a\x00", "a\x00\x00", "a\x00\x01", "a\x00b", "a\x01", "a\xff", "ab", "b"}
+	requireCodec(t, String, strings, func(a, b string) bool { return a < b })
+	requireCodec(t, Bytes, [][]byte{{}, {0}, {0, 1}, {1}}, func(a, b []byte) bool { return bytes.Compare(a, b) < 0 })
+
+	_, err := Int64.Decode([]byte{1, 2, 3})
+	require.Error(t, err)
+	_, err = Uint32.Decode([]byte{1, 2, 3, 4, 5})
+	require.Error(t, err)
+}
+
+func TestPairOf(t *testing.T) {
+	codec := PairOf(String, PairOf(Int64, String))
+	// The pairs are generated in order.
+	pairs := []Pair[string, Pair[int64, string]]{}
+	for _, first := range []string{"", "a", "a\x00", "a\x00\x01", "a\x01", "b"} {
+		for _, second := range []int64{-1, 0, 1} {
+			for _, third := range []string{"", "\x00", "x"} {
+				pairs = append(pairs, Pair[string, Pair[int64, string]]{first, Pair[int64, string]{second, third}})
+			}
+		}
+	}
+	requireCodec(t, codec, pairs, func(a, b Pair[string, Pair[int64, string]]) bool {
+		if a.First != b.First {
+			return a.First < b.First
+		}
+		if a.Second.First != b.Second.First {
+			return a.Second.First < b.Second.First
+		}
+		return a.Second.Second < b.Second.Second
+	})
+
+	for _, bz := range [][]byte{{}, {'a'}, {'a', 0}, {'a', 0, 2}} {
+		_, err := codec.Decode(bz)
+		require.Error(t, err, "%X", bz)
+	}
+	_, err := codec.Decode([]byte{'a', 0, 1, 1})
+	require.Error(t, err)
+}
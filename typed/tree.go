@@ -0,0 +1,191 @@
+// Package typed wraps IAVL trees with typed keys and values, encoded with pluggable codecs. Keys
+// are encoded with order-preserving codecs, so that iteration, range queries and proofs can be
+// expressed with typed bounds. Proofs are for the encoded keys and values, and verify against the
+// root hash of the underlying tree.
+//
+// The package is a separate module, since it uses generics and requires Go 1.18.
+package typed
+
+import (
+	ics23 "github.com/confio/ics23/go"
+	"github.com/pkg/errors"
+
+	"github.com/cosmos/iavl"
+)
+
+// TypedTree wraps a MutableTree with typed keys and values. The methods reading from the tree use
+// its working tree.
+type TypedTree[K, V any] struct {
+	reader[K, V]
+	tree *iavl.MutableTree
+}
+
+// New wraps a MutableTree, encoding keys and values with the given codecs. The key codec must
+// preserve order.
+func New[K, V any](tree *iavl.MutableTree, keys Codec[K], values Codec[V]) *TypedTree[K, V] {
+	return &TypedTree[K, V]{
+		reader: reader[K, V]{
+			tree:   func() *iavl.ImmutableTree { return tree.ImmutableTree },
+			keys:   keys,
+			values: values,
+		},
+		tree: tree,
+	}
+}
+
+// Tree returns the underlying tree, e.g. to save versions.
+func (t *TypedTree[K, V]) Tree() *iavl.MutableTree {
+	return t.tree
+}
+
+// Set sets a key in the working tree. It returns true when an existing value was updated, while
+// false means it was a new key.
+func (t *TypedTree[K, V]) Set(key K, value V) (updated bool) {
+	return t.tree.Set(encode(t.keys, key), encode(t.values, value))
+}
+
+// Remove removes a key from the working tree, and returns its value if it was removed.
+func (t *TypedTree[K, V]) Remove(key K) (value V, removed bool, err error) {
+	bz, removed := t.tree.Remove(encode(t.keys, key))
+	if !removed {
+		return value, false, nil
+	}
+	value, err = t.values.Decode(bz)
+	return value, true, err
+}
+
+// GetImmutable returns a saved version of the tree, with the same codecs.
+func (t *TypedTree[K, V]) GetImmutable(version int64) (*TypedImmutableTree[K, V], error) {
+	tree, err := t.tree.GetImmutable(version)
+	if err != nil {
+		return nil, err
+	}
+	return NewImmutable(tree, t.keys, t.values), nil
+}
+
+// TypedImmutableTree wraps an ImmutableTree with typed keys and values.
+type TypedImmutableTree[K, V any] struct {
+	reader[K, V]
+	tree *iavl.ImmutableTree
+}
+
+// NewImmutable wraps an ImmutableTree, encoding keys and values with the given codecs. The key
+// codec must preserve order.
+func NewImmutable[K, V any](tree *iavl.ImmutableTree, keys Codec[K], values Codec[V]) *TypedImmutableTree[K, V] {
+	return &TypedImmutableTree[K, V]{
+		reader: reader[K, V]{
+			tree:   func() *iavl.ImmutableTree { return tree },
+			keys:   keys,
+			values: values,
+		},
+		tree: tree,
+	}
+}
+
+// Tree returns the underlying tree.
+func (t *TypedImmutableTree[K, V]) Tree() *iavl.ImmutableTree {
+	return t.tree
+}
+
+// reader implements the read methods shared by both tree types. The MutableTree replaces its
+// working tree when saving a version, so it is looked up on every call.
+type reader[K, V any] struct {
+	tree   func() *iavl.ImmutableTree
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// Get returns the value of the specified key, and whether it exists.
+func (r reader[K, V]) Get(key K) (value V, ok bool, err error) {
+	_, bz := r.tree().Get(encode(r.keys, key))
+	if bz == nil {
+		return value, false, nil
+	}
+	value, err = r.values.Decode(bz)
+	return value, true, err
+}
+
+// Has returns whether or not a key exists.
+func (r reader[K, V]) Has(key K) bool {
+	return r.tree().Has(encode(r.keys, key))
+}
+
+// Iterate iterates over all keys of the tree, in order. Iteration stops with an error if a key or
+// value can't be decoded.
+func (r reader[K, V]) Iterate(fn func(key K, value V) bool) (stopped bool, err error) {
+	return r.IterateRange(nil, nil, true, fn)
+}
+
+// IterateRange iterates over the keys in the range [start, end), where a nil bound is open.
+// Iteration stops with an error if a key or value can't be decoded.
+func (r reader[K, V]) IterateRange(start, end *K, ascending bool, fn func(key K, value V) bool) (stopped bool, err error) {
+	stopped = r.tree().IterateRange(r.bound(start), r.bound(end), ascending, func(k, v []byte) bool {
+		var key K
+		var value V
+		if key, value, err = r.decode(k, v); err != nil {
+			return true
+		}
+		return fn(key, value)
+	})
+	return stopped && err == nil, err
+}
+
+// CountRange returns the number of keys in the range [start, end), where a nil bound is open.
+func (r reader[K, V]) CountRange(start, end *K) int64 {
+	return r.tree().CountRange(r.bound(start), r.bound(end))
+}
+
+// GetWithProof gets the value of a key along with a proof of its existence or absence, for the
+// encoded key and value.
+func (r reader[K, V]) GetWithProof(key K) (value V, ok bool, proof *iavl.RangeProof, err error) {
+	bz, proof, err := r.tree().GetWithProof(encode(r.keys, key))
+	if err != nil || bz == nil {
+		return value, false, proof, err
+	}
+	value, err = r.values.Decode(bz)
+	return value, true, proof, err
+}
+
+// GetRangeWithProof gets the keys and values in the range [start, end), where a nil bound is
+// open, along with a proof of the encoded keys and values. The limit applies to the leaves of the
+// proof, as for ImmutableTree.GetRangeWithProof(), and 0 is unlimited.
+func (r reader[K, V]) GetRangeWithProof(start, end *K, limit int) (keys []K, values []V, proof *iavl.RangeProof, err error) {
+	rawKeys, rawValues, proof, err := r.tree().GetRangeWithProof(r.bound(start), r.bound(end), limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys, values = make([]K, len(rawKeys)), make([]V, len(rawValues))
+	for i := range rawKeys {
+		if keys[i], values[i], err = r.decode(rawKeys[i], rawValues[i]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return keys, values, proof, nil
+}
+
+// GetMembershipProof returns an ICS23 proof that the encoded key exists in the tree.
+func (r reader[K, V]) GetMembershipProof(key K) (*ics23.CommitmentProof, error) {
+	return r.tree().GetMembershipProof(encode(r.keys, key))
+}
+
+// GetNonMembershipProof returns an ICS23 proof that the encoded key does not exist in the tree.
+func (r reader[K, V]) GetNonMembershipProof(key K) (*ics23.CommitmentProof, error) {
+	return r.tree().GetNonMembershipProof(encode(r.keys, key))
+}
+
+func (r reader[K, V]) bound(key *K) []byte {
+	if key == nil {
+		return nil
+	}
+	return encode(r.keys, *key)
+}
+
+func (r reader[K, V]) decode(k, v []byte) (key K, value V, err error) {
+	if key, err = r.keys.Decode(k); err != nil {
+		return key, value, errors.Wrapf(err, "decoding key %X", k)
+	}
+	if value, err = r.values.Decode(v); err != nil {
+		return key, value, errors.Wrapf(err, "decoding value of key %X", k)
+	}
+	return key, value, nil
+}
@@ -0,0 +1,103 @@
+package typed
+
+import (
+	"testing"
+
+	ics23 "github.com/confio/ics23/go"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+
+	"github.com/cosmos/iavl"
+	iavlproto "github.com/cosmos/iavl/proto"
+)
+
+type accountKey = Pair[string, uint64]
+
+var (
+	accountKeys   = PairOf(String, Uint64)
+	accountValues = Proto(func() *iavlproto.ProofLeafNode { return &iavlproto.ProofLeafNode{} })
+)
+
+func TestTypedTree(t *testing.T) {
+	mutable, err := iavl.NewMutableTree(db.NewMemDB(), 0)
+	require.NoError(t, err)
+	tree := New(mutable, accountKeys, accountValues)
+
+	owners := []string{"alice", "bob", "bob\x00"}
+	for _, owner := range owners {
+		for id := uint64(0); id < 300; id += 100 {
+			require.False(t, tree.Set(accountKey{owner, id}, &iavlproto.ProofLeafNode{Key: []byte(owner), Version: int64(id)}))
+		}
+	}
+	require.True(t, tree.Set(accountKey{"alice", 0}, &iavlproto.ProofLeafNode{Version: 1}))
+
+	value, ok, err := tree.Get(accountKey{"alice", 0})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.EqualValues(t, 1, value.Version)
+	_, ok, err = tree.Get(accountKey{"alice", 1})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.True(t, tree.Has(accountKey{"bob", 200}))
+
+	value, removed, err := tree.Remove(accountKey{"alice", 0})
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.EqualValues(t, 1, value.Version)
+	_, removed, err = tree.Remove(accountKey{"alice", 0})
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	_, version, err := tree.Tree().SaveVersion()
+	require.NoError(t, err)
+	tree.Set(accountKey{"carol", 0}, &iavlproto.ProofLeafNode{})
+
+	// Ranges use encoded bounds, so the accounts of bob end before those of "bob\x00".
+	start, end := accountKey{"bob", 0}, accountKey{"bob", 1000}
+	keys := []accountKey{}
+	stopped, err := tree.IterateRange(&start, &end, false, func(key accountKey, value *iavlproto.ProofLeafNode) bool {
+		require.EqualValues(t, key.Second, value.Version)
+		keys = append(keys, key)
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, []accountKey{{"bob", 200}, {"bob", 100}, {"bob", 0}}, keys)
+	require.EqualValues(t, 3, tree.CountRange(&start, &end))
+	require.EqualValues(t, 7, tree.CountRange(&start, nil))
+
+	count := 0
+	stopped, err = tree.Iterate(func(key accountKey, value *iavlproto.ProofLeafNode) bool {
+		count++
+		return count == 2
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+
+	// Saved versions and proofs use the encoded keys and values.
+	saved, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+	require.False(t, saved.Has(accountKey{"carol", 0}))
+	root := saved.Tree().Hash()
+
+	value, ok, proof, err := saved.GetWithProof(accountKey{"bob", 100})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, proof.Verify(root))
+	require.NoError(t, proof.VerifyItem(accountKeys.Encode(accountKey{"bob", 100}), accountValues.Encode(value)))
+
+	keys, values, proof, err := saved.GetRangeWithProof(&start, nil, 5)
+	require.NoError(t, err)
+	require.Equal(t, []accountKey{{"bob", 0}, {"bob", 100}, {"bob", 200}, {"bob\x00", 0}}, keys)
+	require.Len(t, values, 4)
+	require.NoError(t, proof.Verify(root))
+
+	ics23Proof, err := saved.GetNonMembershipProof(accountKey{"alice", 0})
+	require.NoError(t, err)
+	require.True(t, ics23.VerifyNonMembership(ics23.IavlSpec, root, ics23Proof, accountKeys.Encode(accountKey{"alice", 0})))
+
+	// Values that can't be decoded stop iteration with an error.
+	tree.Tree().Set([]byte("invalid"), []byte("value"))
+	_, err = tree.Iterate(func(key accountKey, value *iavlproto.ProofLeafNode) bool { return false })
+	require.Error(t, err)
+}
@@ -53,6 +53,13 @@ func maxInt8(a, b int8) int8 {
 	return b
 }
 
+func minInt8(a, b int8) int8 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func cp(bz []byte) (ret []byte) {
 	ret = make([]byte, len(bz))
 	copy(ret, bz)
@@ -0,0 +1,253 @@
+package iavl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Witness is a partial tree containing the nodes that were read from a tree while recording
+// accesses, e.g. while executing a block. Subtrees that were not read are pruned, and only their
+// hash is kept as a child hash of the nodes in the witness. The witness can be serialized with
+// Marshal, and checked against the root hash of the tree it was recorded from with Verify.
+//
+// Note that the hash of an inner node does not cover its key, which is the lowest key of its
+// right subtree. Verify checks that inner node keys are consistent with the ordering of the
+// other keys in the witness, but a key may only be fully checked if the leftmost leaf of the
+// right subtree is included.
+type Witness struct {
+	root    []byte           // hash of the root node, or of an empty input for an empty tree
+	version int64            // version of the tree, which is not covered by the root hash
+	nodes   map[string]*Node // touched nodes, by hash
+}
+
+// witnessRecorder records the persisted nodes read from a tree.
+type witnessRecorder struct {
+	mtx     sync.Mutex
+	version int64 // nodes above this version were created after recording started
+	root    []byte
+	nodes   map[string]*Node
+}
+
+func (r *witnessRecorder) record(node *Node) {
+	if !node.persisted || node.version > r.version {
+		return
+	}
+	r.mtx.Lock()
+	r.nodes[string(node.hash)] = node
+	r.mtx.Unlock()
+}
+
+// StartRecording starts recording every node that is read from the tree, until StopRecording is
+// called. For a MutableTree, the tree must not have unsaved changes, and the recorded nodes are
+// those of the latest saved version. Nodes created by changes to the working tree are not
+// recorded. Recording continues across SaveVersion, but only nodes of the original version are
+// recorded. StartRecording and StopRecording must not be called concurrently with other tree
+// operations.
+func (t *ImmutableTree) StartRecording() error {
+	if t.witness != nil {
+		return errors.New("already recording")
+	}
+	if t.root != nil && !t.root.persisted {
+		return errors.New("cannot record a tree with unsaved changes")
+	}
+	root, _ := t.root.hashWithCount()
+	t.witness = &witnessRecorder{
+		version: t.version,
+		root:    root,
+		nodes:   map[string]*Node{},
+	}
+	if t.root != nil {
+		t.witness.record(t.root)
+	}
+	return nil
+}
+
+// StopRecording stops recording node accesses, and returns a witness of the nodes read since
+// StartRecording was called.
+func (t *ImmutableTree) StopRecording() (*Witness, error) {
+	if t.witness == nil {
+		return nil, errors.New("not recording")
+	}
+	r := t.witness
+	t.witness = nil
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return &Witness{root: r.root, version: r.version, nodes: r.nodes}, nil
+}
+
+// Root returns the root hash of the tree the witness was recorded from.
+func (w *Witness) Root() []byte {
+	return w.root
+}
+
+// Version returns the version of the tree the witness was recorded from. Note that the version is
+// not covered by the root hash, and must be trusted or checked separately.
+func (w *Witness) Version() int64 {
+	return w.version
+}
+
+// Len returns the number of nodes in the witness.
+func (w *Witness) Len() int {
+	return len(w.nodes)
+}
+
+// Verify checks that the witness is a partial tree with the given root hash: the root node must be
+// in the witness, every other node must be reachable from it, and the keys must be ordered.
+func (w *Witness) Verify(root []byte) error {
+	if !bytes.Equal(w.root, root) {
+		return errors.Errorf("witness is for root %X, expected %X", w.root, root)
+	}
+	if bytes.Equal(root, sha256.New().Sum(nil)) && len(w.nodes) == 0 {
+		return nil
+	}
+	rootNode, ok := w.nodes[string(root)]
+	if !ok {
+		return errors.New("witness does not contain the root node")
+	}
+
+	// Walk the partial tree, keeping track of the key range each subtree must be in, and the key
+	// its leftmost leaf must have (the key of the inner node it is the right subtree of).
+	seen := 0
+	var walk func(node *Node, low, high, leftmost []byte) error
+	walk = func(node *Node, low, high, leftmost []byte) error {
+		seen++
+		if low != nil && bytes.Compare(node.key, low) < 0 || high != nil && bytes.Compare(node.key, high) >= 0 {
+			return errors.Errorf("node key %X out of range", node.key)
+		}
+		if node.isLeaf() {
+			if leftmost != nil && !bytes.Equal(node.key, leftmost) {
+				return errors.Errorf("leaf key %X does not match inner node key %X", node.key, leftmost)
+			}
+			return nil
+		}
+		if left, ok := w.nodes[string(node.leftHash)]; ok {
+			if err := walk(left, low, node.key, leftmost); err != nil {
+				return err
+			}
+		}
+		if right, ok := w.nodes[string(node.rightHash)]; ok {
+			if err := walk(right, node.key, high, node.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(rootNode, nil, nil, nil); err != nil {
+		return err
+	}
+	if seen != len(w.nodes) {
+		return errors.Errorf("witness contains %v nodes that are not reachable from the root", len(w.nodes)-seen)
+	}
+	return nil
+}
+
+// Marshal encodes the witness as the root hash and tree version followed by the nodes in
+// pre-order, using the same node encoding as the database.
+func (w *Witness) Marshal() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := encodeBytes(buf, w.root)
+	if err == nil {
+		err = encodeVarint(buf, w.version)
+	}
+	if err == nil {
+		err = encodeUvarint(buf, uint64(len(w.nodes)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Nodes that are not reachable from the root (which Verify rejects) are appended in hash order,
+	// so that the encoding is deterministic.
+	written := make(map[string]bool, len(w.nodes))
+	var write func(hash []byte) error
+	write = func(hash []byte) error {
+		node, ok := w.nodes[string(hash)]
+		if !ok || written[string(hash)] {
+			return nil
+		}
+		written[string(hash)] = true
+		nodeBuf := new(bytes.Buffer)
+		if err := node.writeBytes(nodeBuf); err != nil {
+			return err
+		}
+		if err := encodeBytes(buf, nodeBuf.Bytes()); err != nil {
+			return err
+		}
+		if node.isLeaf() {
+			return nil
+		}
+		if err := write(node.leftHash); err != nil {
+			return err
+		}
+		return write(node.rightHash)
+	}
+	if err := write(w.root); err != nil {
+		return nil, err
+	}
+	if len(written) < len(w.nodes) {
+		rest := make([][]byte, 0, len(w.nodes)-len(written))
+		for hash := range w.nodes {
+			if !written[hash] {
+				rest = append(rest, []byte(hash))
+			}
+		}
+		for _, hash := range sortByteSlices(rest) {
+			if err := write(hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalWitness decodes a witness encoded with Witness.Marshal. The node hashes are recomputed
+// from their contents, but the witness must still be checked against a root hash with Verify.
+func UnmarshalWitness(bz []byte) (*Witness, error) {
+	root, n, err := decodeBytes(bz)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding witness root")
+	}
+	bz = bz[n:]
+	version, n, err := decodeVarint(bz)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding witness version")
+	}
+	bz = bz[n:]
+	count, n, err := decodeUvarint(bz)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding witness size")
+	}
+	bz = bz[n:]
+	if count > uint64(len(bz)) {
+		return nil, errors.Errorf("invalid witness size %v", count)
+	}
+
+	w := &Witness{root: root, version: version, nodes: make(map[string]*Node, count)}
+	for i := uint64(0); i < count; i++ {
+		nodeBz, n, err := decodeBytes(bz)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding witness node %v", i)
+		}
+		bz = bz[n:]
+		node, err := MakeNode(nodeBz)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding witness node %v", i)
+		}
+		if err = node.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid witness node %v", i)
+		}
+		node._hash()
+		node.persisted = true
+		if _, ok := w.nodes[string(node.hash)]; ok {
+			return nil, errors.Errorf("duplicate witness node %X", node.hash)
+		}
+		w.nodes[string(node.hash)] = node
+	}
+	if len(bz) > 0 {
+		return nil, errors.Errorf("%v unexpected trailing bytes in witness", len(bz))
+	}
+	return w, nil
+}
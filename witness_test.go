@@ -0,0 +1,117 @@
+package iavl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// setupWitnessTree sets up a saved tree with 1000 keys, for recording witnesses.
+func setupWitnessTree(t *testing.T) *MutableTree {
+	tree, err := NewMutableTree(db.NewMemDB(), 0)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		tree.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%04d", i)))
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	return tree
+}
+
+func TestWitness(t *testing.T) {
+	tree := setupWitnessTree(t)
+	root := tree.Hash()
+
+	require.NoError(t, tree.StartRecording())
+	require.Error(t, tree.StartRecording())
+	_, value := tree.Get([]byte("key0001"))
+	require.Equal(t, []byte("value0001"), value)
+	_, value = tree.Get([]byte("foo"))
+	require.Nil(t, value)
+	tree.Set([]byte("key0500"), []byte("new"))
+	tree.Set([]byte("key0500a"), []byte("new"))
+	tree.Remove([]byte("key0900"))
+	tree.IterateRange([]byte("key0200"), []byte("key0205"), true, func(key, value []byte) bool {
+		return false
+	})
+	// Recording continues across saves, but only for nodes of the original version.
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	_, value = tree.Get([]byte("key0700"))
+	require.Equal(t, []byte("value0700"), value)
+	_, value = tree.Get([]byte("key0500a"))
+	require.Equal(t, []byte("new"), value)
+
+	witness, err := tree.StopRecording()
+	require.NoError(t, err)
+	_, err = tree.StopRecording()
+	require.Error(t, err)
+
+	require.Equal(t, root, witness.Root())
+	require.NoError(t, witness.Verify(root))
+	require.Error(t, witness.Verify(tree.Hash()))
+	require.True(t, witness.Len() < 150, "witness has %v nodes", witness.Len())
+
+	leaves := map[string]bool{}
+	for _, node := range witness.nodes {
+		require.LessOrEqual(t, node.version, int64(1))
+		if node.isLeaf() {
+			leaves[string(node.key)] = true
+		}
+	}
+	for _, key := range []string{"key0001", "key0500", "key0900", "key0200", "key0204", "key0700"} {
+		require.True(t, leaves[key], "missing leaf %v", key)
+	}
+	require.False(t, leaves["key0500a"])
+
+	// Serialization round-trips, and is deterministic.
+	bz, err := witness.Marshal()
+	require.NoError(t, err)
+	decoded, err := UnmarshalWitness(bz)
+	require.NoError(t, err)
+	require.NoError(t, decoded.Verify(root))
+	require.Equal(t, witness.Len(), decoded.Len())
+	bz2, err := decoded.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, bz, bz2)
+
+	_, err = UnmarshalWitness(bz[:len(bz)-1])
+	require.Error(t, err)
+	_, err = UnmarshalWitness(append(bz, 0x01))
+	require.Error(t, err)
+
+	// Nodes that are not part of the tree are rejected.
+	stray := NewNode([]byte("stray"), []byte("stray"), 1)
+	stray._hash()
+	stray.persisted = true
+	decoded.nodes[string(stray.hash)] = stray
+	require.Error(t, decoded.Verify(root))
+}
+
+func TestWitness_Errors(t *testing.T) {
+	tree := setupWitnessTree(t)
+	tree.Set([]byte("foo"), []byte("bar"))
+	require.Error(t, tree.StartRecording())
+	tree.Rollback()
+
+	// An immutable tree can be recorded too, and an empty tree has an empty witness.
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	require.NoError(t, itree.StartRecording())
+	itree.Has([]byte("key0123"))
+	witness, err := itree.StopRecording()
+	require.NoError(t, err)
+	require.NoError(t, witness.Verify(tree.Hash()))
+
+	empty, err := NewMutableTree(db.NewMemDB(), 0)
+	require.NoError(t, err)
+	require.NoError(t, empty.StartRecording())
+	empty.Get([]byte("foo"))
+	witness, err = empty.StopRecording()
+	require.NoError(t, err)
+	require.Zero(t, witness.Len())
+	require.NoError(t, witness.Verify(empty.Hash()))
+}